@@ -1,92 +1,527 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"os/user"
 	"path"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
 
 	"github.com/sbreitf1/go-console"
 	"github.com/sbreitf1/go-jcrypt"
+	"golang.org/x/term"
+)
+
+const (
+	configDirPerm  = 0700
+	configFilePerm = 0600
+
+	// DefaultProfile is the name of the profile used when none is given.
+	DefaultProfile = "default"
+
+	// profileFileVersion is the format version of written profile files. Files without version header are version 0.
+	profileFileVersion = 1
 )
 
 var (
 	key = []byte{42, 13, 37}
+
+	patternProfileName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+	// ErrNoConfigDir is returned when no directory for stored files can be determined.
+	ErrNoConfigDir = fmt.Errorf("config directory unknown")
+
+	configDirOverride string
+
+	// profileMigrations upgrade the JSON object of a profile file, the function at index i migrates from version i to i+1.
+	profileMigrations = []func(raw map[string]interface{}) error{
+		// version 1 only adds the version header
+		func(raw map[string]interface{}) error { return nil },
+	}
 )
 
+// SetConfigDir sets the directory for all stored files explicitly, e.g. for programs embedding gohome. It takes precedence over GOHOME_CONFIG_DIR and the home directory.
+func SetConfigDir(dir string) error {
+	if len(strings.TrimSpace(dir)) == 0 {
+		return ErrNoConfigDir
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	configDirOverride = absDir
+	return nil
+}
+
 func getConfigDir() (string, error) {
-	usr, err := user.Current()
+	if len(configDirOverride) > 0 {
+		return configDirOverride, nil
+	}
+
+	dir, err := resolveConfigDir(os.Getenv, func() (string, error) {
+		usr, err := user.Current()
+		if err != nil {
+			return "", err
+		}
+		return usr.HomeDir, nil
+	})
 	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrNoConfigDir, err.Error())
+	}
+	return dir, nil
+}
+
+// resolveConfigDir returns the directory from GOHOME_CONFIG_DIR if set. Otherwise ~/.gohome is used and $XDG_CONFIG_HOME/gohome if the home directory is unknown.
+func resolveConfigDir(getenv func(string) string, homeDir func() (string, error)) (string, error) {
+	if dir := getenv("GOHOME_CONFIG_DIR"); len(dir) > 0 {
+		return dir, nil
+	}
+
+	home, err := homeDir()
+	if err == nil && len(home) > 0 {
+		return path.Join(home, ".gohome"), nil
+	}
+
+	if xdgDir := getenv("XDG_CONFIG_HOME"); len(xdgDir) > 0 {
+		return path.Join(xdgDir, "gohome"), nil
+	}
+	if err == nil {
+		err = fmt.Errorf("home directory unknown")
+	}
+	return "", err
+}
+
+// Config contains the settings for all files stored by gohome. It allows programs embedding gohome to use their own directory and credential store.
+type Config struct {
+	// Dir is the directory for all stored files.
+	Dir string
+	// CredentialStore persists the Matrix configuration of profiles. A FileCredentialStore in Dir is used if nil.
+	CredentialStore CredentialStore
+	// Prompter asks for a missing configuration and passphrases. DefaultPrompter is used if nil.
+	Prompter Prompter
+}
+
+// DefaultConfig returns the settings used by the command line tool: the directory set by SetConfigDir, GOHOME_CONFIG_DIR or in the home directory and DefaultCredentialStore.
+func DefaultConfig() (Config, error) {
+	dir, err := getConfigDir()
+	if err != nil {
+		return Config{}, err
+	}
+	return Config{Dir: dir, CredentialStore: DefaultCredentialStore}, nil
+}
+
+func (c Config) dir() (string, error) {
+	if len(c.Dir) == 0 {
+		return "", ErrNoConfigDir
+	}
+	return c.Dir, nil
+}
+
+// createDir returns the config directory and creates it if missing.
+func (c Config) createDir() (string, error) {
+	dir, err := c.dir()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, configDirPerm); err != nil {
 		return "", err
 	}
-	return path.Join(usr.HomeDir, ".gohome"), nil
+	return dir, nil
 }
 
+func (c Config) credentialStore() CredentialStore {
+	if c.CredentialStore == nil {
+		return FileCredentialStore{Dir: c.Dir, Prompter: c.Prompter}
+	}
+	return c.CredentialStore
+}
+
+// GetMatrixConfig returns the Matrix configuration of the default profile.
 func GetMatrixConfig() (MatrixConfig, error) {
-	configDir, err := getConfigDir()
+	return GetMatrixConfigForProfile(DefaultProfile)
+}
+
+// GetMatrixConfigForProfile returns the Matrix configuration for a named profile using DefaultConfig. See Config.MatrixConfigForProfile for details.
+func GetMatrixConfigForProfile(profile string) (MatrixConfig, error) {
+	// an unknown config directory is not an error as long as the configuration is defined by environment variables
+	config, err := DefaultConfig()
 	if err != nil {
-		return MatrixConfig{}, err
+		config = Config{CredentialStore: DefaultCredentialStore}
 	}
+	return config.MatrixConfigForProfile(profile)
+}
 
-	configFile := filepath.Join(configDir, "matrix.json")
-	data, err := ioutil.ReadFile(configFile)
-	if err != nil {
-		if os.IsNotExist(err) {
-			config, err := enterMatrixConfig()
+// MatrixConfigForProfile returns the stored Matrix configuration for a named profile and asks the user to enter a new one if missing.
+// The environment variables GOHOME_HOST and GOHOME_USER take precedence over the stored configuration and GOHOME_PASS overrides the stored password.
+// A ".gohome-host" file in the working directory or one of its parents overrides the stored host and a message names the file. Neither the stored password, GOHOME_PASS nor the default entry of the .netrc file are used for a different host.
+// A missing password is looked up in the .netrc file given by NETRC or in the home directory.
+func (c Config) MatrixConfigForProfile(profile string) (MatrixConfig, error) {
+	config, ok := matrixConfigFromEnv(os.Getenv)
+	hostOverridden := false
+	if !ok {
+		var err error
+		config, err = getStoredMatrixConfig(c.credentialStore(), orDefaultPrompter(c.Prompter), profile)
+		if err != nil {
+			return MatrixConfig{}, err
+		}
+
+		if wd, err := os.Getwd(); err == nil {
+			host, file, err := findProjectHost(wd)
 			if err != nil {
 				return MatrixConfig{}, err
 			}
-
-			if err := os.MkdirAll(configDir, os.ModePerm); err != nil {
-				console.Printlnf("Failed to store configuration: %s", err.Error())
-			}
-			if err := jcrypt.MarshalToFile(configFile, &config, &jcrypt.Options{GetKeyHandler: jcrypt.StaticKey(key)}); err != nil {
-				console.Printlnf("Failed to store configuration: %s", err.Error())
+			if len(host) > 0 && host != config.Host {
+				// never send the stored password to another host
+				console.Printlnf("Using host %s from %q instead of %s", host, file, config.Host)
+				config.Host = host
+				config.Pass = ""
+				hostOverridden = true
 			}
-			return config, nil
 		}
-		return MatrixConfig{}, err
+
+		if pass := os.Getenv("GOHOME_PASS"); len(pass) > 0 && !hostOverridden {
+			config.Pass = pass
+		}
 	}
 
-	var config MatrixConfig
-	if err := jcrypt.Unmarshal(data, &config, &jcrypt.Options{GetKeyHandler: jcrypt.StaticKey(key)}); err != nil {
+	if len(config.Pass) == 0 {
+		// a catch-all password is meant for the stored host, not for the project host
+		applyNetrc(&config, !hostOverridden)
+	}
+	return config, nil
+}
+
+// matrixConfigFromEnv returns the configuration defined by environment variables. The second return value is false if host or user are not set.
+func matrixConfigFromEnv(getenv func(string) string) (MatrixConfig, bool) {
+	config := MatrixConfig{
+		Host: getenv("GOHOME_HOST"),
+		User: getenv("GOHOME_USER"),
+		Pass: getenv("GOHOME_PASS"),
+	}
+	if len(config.Host) == 0 || len(config.User) == 0 {
+		return MatrixConfig{}, false
+	}
+	return config, true
+}
+
+// getStoredMatrixConfig loads the configuration of a profile from store and asks the user to create it if missing.
+func getStoredMatrixConfig(store CredentialStore, prompter Prompter, profile string) (MatrixConfig, error) {
+	config, ok, err := store.Load(profile)
+	if err != nil {
 		return MatrixConfig{}, err
 	}
+	if ok {
+		return config, nil
+	}
 
+	config, err = enterMatrixConfig(prompter)
+	if err != nil {
+		return MatrixConfig{}, err
+	}
+	if err := store.Save(profile, config); err != nil {
+		console.Printlnf("Failed to store configuration: %s", err.Error())
+	}
 	return config, nil
 }
 
-func enterMatrixConfig() (MatrixConfig, error) {
-	console.Printlnf("Please enter your Matrix configuration below:")
-	console.Print("Host> ")
-	host, err := console.ReadLine()
+// getProfileFile returns the path of the configuration file for a profile. The default profile uses matrix.json for compatibility.
+func getProfileFile(configDir, profile string) (string, error) {
+	return getProfilePath(configDir, "matrix", profile)
+}
+
+// getProfilePath returns the path of a profile specific file like "<name>-<profile>.json" or "<name>.json" for the default profile.
+func getProfilePath(configDir, name, profile string) (string, error) {
+	if len(profile) == 0 || profile == DefaultProfile {
+		return filepath.Join(configDir, name+".json"), nil
+	}
+	if !patternProfileName.MatchString(profile) {
+		return "", fmt.Errorf("invalid profile name %q", profile)
+	}
+	return filepath.Join(configDir, name+"-"+profile+".json"), nil
+}
+
+// GetSessionCacheFile returns the file used to cache the Matrix session of a profile between runs using DefaultConfig.
+func GetSessionCacheFile(profile string) (string, error) {
+	config, err := DefaultConfig()
+	if err != nil {
+		return "", err
+	}
+	return config.SessionCacheFile(profile)
+}
+
+// SessionCacheFile returns the file used to cache the Matrix session of a profile between runs.
+func (c Config) SessionCacheFile(profile string) (string, error) {
+	configDir, err := c.createDir()
+	if err != nil {
+		return "", err
+	}
+	return getProfilePath(configDir, "session", profile)
+}
+
+// storedMatrixConfig is the format of profile files.
+type storedMatrixConfig struct {
+	Version int    `json:"version"`
+	Host    string `json:"host"`
+	User    string `json:"user"`
+	Pass    string `json:"pass" jcrypt:"aes"`
+}
+
+// writeMatrixConfig encrypts the configuration with passphrase and writes it to a file only accessible by the current user.
+func writeMatrixConfig(file string, config MatrixConfig, passphrase []byte) error {
+	stored := storedMatrixConfig{Version: profileFileVersion, Host: config.Host, User: config.User, Pass: config.Pass}
+	data, err := jcrypt.Marshal(&stored, &jcrypt.Options{GetKeyHandler: jcrypt.StaticKey(passphrase)})
+	if err != nil {
+		return err
+	}
+	return writePrivateFile(file, data)
+}
+
+// writePrivateFile atomically replaces a file with data. The file is only accessible by the current user.
+func writePrivateFile(file string, data []byte) error {
+	// write to a temporary file in the same directory first, so an interrupted write never leaves a truncated file behind
+	tmpFile, err := ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmpFile.Name()
+	defer os.Remove(tmpName)
+
+	if err := tmpFile.Chmod(configFilePerm); err != nil && runtime.GOOS != "windows" {
+		tmpFile.Close()
+		return err
+	}
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, file)
+}
+
+// warnPermissiveMode prints a warning if the given file or directory is accessible by other users.
+func warnPermissiveMode(file string) {
+	if runtime.GOOS == "windows" {
+		// file modes do not reflect access rights on windows
+		return
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		return
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		console.Printlnf("Warning: %q is accessible by other users (mode %s), consider running 'chmod go-rwx %s'", file, info.Mode().Perm(), file)
+	}
+}
+
+// unmarshalMatrixConfig decrypts a stored configuration and returns the key that has been used. The user is asked for a passphrase if the default key does not match.
+func unmarshalMatrixConfig(data []byte, prompter Prompter) (MatrixConfig, []byte, error) {
+	var config MatrixConfig
+	err := jcrypt.Unmarshal(data, &config, &jcrypt.Options{GetKeyHandler: jcrypt.StaticKey(key)})
+	if err == nil {
+		return config, key, nil
+	}
+	if !jcrypt.IsWrongPassword(err) {
+		return MatrixConfig{}, nil, err
+	}
+
+	passphrase, err := prompter.PromptPassword("Passphrase to decrypt Matrix configuration")
+	if err != nil {
+		return MatrixConfig{}, nil, err
+	}
+	if err := jcrypt.Unmarshal(data, &config, &jcrypt.Options{GetKeyHandler: jcrypt.StaticKey([]byte(passphrase))}); err != nil {
+		if jcrypt.IsWrongPassword(err) {
+			return MatrixConfig{}, nil, fmt.Errorf("wrong passphrase")
+		}
+		return MatrixConfig{}, nil, err
+	}
+	return config, []byte(passphrase), nil
+}
+
+// migrateProfileData upgrades the content of a profile file to profileFileVersion. The second return value is true if the content has changed and should be written back.
+func migrateProfileData(data []byte) ([]byte, bool, error) {
+	raw, err := readRawProfileData(data)
+	if err != nil {
+		return nil, false, err
+	}
+	version, err := profileVersion(raw)
+	if err != nil {
+		return nil, false, err
+	}
+	if version > profileFileVersion {
+		return nil, false, fmt.Errorf("profile file version %d is not supported by this version of gohome", version)
+	}
+	if version == profileFileVersion {
+		return data, false, nil
+	}
+
+	for v := version; v < profileFileVersion; v++ {
+		if err := profileMigrations[v](raw); err != nil {
+			return nil, false, fmt.Errorf("failed to migrate profile file from version %d: %w", v, err)
+		}
+	}
+	raw["version"] = profileFileVersion
+	migrated, err := json.Marshal(raw)
+	if err != nil {
+		return nil, false, err
+	}
+	return migrated, true, nil
+}
+
+// profileVersion returns the version header of a profile file or 0 if missing.
+func profileVersion(raw map[string]interface{}) (int, error) {
+	value, ok := raw["version"]
+	if !ok {
+		return 0, nil
+	}
+	version, ok := value.(float64)
+	if !ok || version < 0 || version != float64(int(version)) {
+		return 0, fmt.Errorf("invalid profile file version %v", value)
+	}
+	return int(version), nil
+}
+
+// isPlainMatrixConfig returns true if the stored password is not encrypted.
+func isPlainMatrixConfig(data []byte) bool {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return false
+	}
+	pass, ok := raw["pass"].(string)
+	return ok && len(pass) > 0
+}
+
+// enterMatrixConfig asks for a new configuration. All output goes through prompter, so the labels name the Matrix configuration and an invalid host is reported in the label of the next host prompt.
+func enterMatrixConfig(prompter Prompter) (MatrixConfig, error) {
+	var host string
+	label := "Matrix host"
+	for {
+		input, err := prompter.PromptUser(label)
+		if err != nil {
+			return MatrixConfig{}, err
+		}
+
+		host, err = normalizeHost(input)
+		if err == nil {
+			break
+		}
+		label = fmt.Sprintf("Invalid host (%s), Matrix host", err.Error())
+	}
+
+	user, err := prompter.PromptUser("Matrix user")
+	if err != nil {
+		return MatrixConfig{}, err
+	}
+
+	pass, err := prompter.PromptPassword("Matrix password")
 	if err != nil {
 		return MatrixConfig{}, err
 	}
 
+	return MatrixConfig{host, user, pass}, nil
+}
+
+// normalizeHost returns the scheme and host part of an URL like "https://matrix.example.com:8443". The scheme defaults to https and any path is removed.
+func normalizeHost(input string) (string, error) {
+	host := strings.TrimSpace(input)
+	if len(host) == 0 {
+		return "", fmt.Errorf("host must not be empty")
+	}
+
 	// ensure protocol is appended
 	if !strings.HasPrefix(strings.ToLower(host), "http://") && !strings.HasPrefix(strings.ToLower(host), "https://") {
 		host = "https://" + host
 	}
+
+	u, err := url.Parse(host)
+	if err != nil {
+		return "", err
+	}
+	if len(u.Hostname()) == 0 || strings.ContainsAny(u.Hostname(), " \t") {
+		return "", fmt.Errorf("%q does not contain a valid host name", input)
+	}
+	if port := u.Port(); len(port) > 0 {
+		if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+			return "", fmt.Errorf("invalid port %q", port)
+		}
+	} else if strings.HasSuffix(u.Host, ":") {
+		return "", fmt.Errorf("missing port after colon")
+	}
+
 	// and now remove path information
-	protIndex := strings.Index(host, "://")
-	if index := strings.Index(host[protIndex+3:], "/"); index >= 0 {
-		host = host[:index+protIndex+3]
+	return strings.ToLower(u.Scheme) + "://" + u.Host, nil
+}
+
+// readPassword reads a password from the terminal without echo. A plain line is read if stdin is no terminal, e.g. when piping the password.
+func readPassword() (string, error) {
+	return readPasswordContext(context.Background())
+}
+
+// readPasswordContext is like readPassword but returns ctx.Err() when ctx is done before the user finished the input. Echo is enabled again in this case.
+func readPasswordContext(ctx context.Context) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return readContext(ctx, console.ReadLine)
 	}
 
-	console.Print("User> ")
-	user, err := console.ReadLine()
+	state, err := term.GetState(fd)
 	if err != nil {
-		return MatrixConfig{}, err
+		return "", err
+	}
+	str, err := readContext(ctx, console.ReadPassword)
+	if ctx.Err() != nil {
+		// the aborted read still has echo disabled
+		term.Restore(fd, state)
+		console.Println()
+	}
+	return str, err
+}
+
+// readString reads a line from stdin.
+func readString() (string, error) {
+	return readStringContext(context.Background())
+}
+
+// readStringContext reads a line from stdin and returns ctx.Err() when ctx is done before the user finished the input.
+func readStringContext(ctx context.Context) (string, error) {
+	return readContext(ctx, console.ReadLine)
+}
+
+// readContext calls read in the background and returns its result or ctx.Err() if ctx is done first.
+// An aborted read keeps blocking until the next line is entered, and this line is discarded.
+func readContext(ctx context.Context, read func() (string, error)) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
 	}
 
-	console.Print("Pass> ")
-	pass, err := console.ReadPassword()
-	if err != nil {
-		return MatrixConfig{}, err
+	type result struct {
+		str string
+		err error
 	}
+	done := make(chan result, 1)
+	go func() {
+		str, err := read()
+		done <- result{str, err}
+	}()
 
-	return MatrixConfig{host, user, pass}, nil
+	select {
+	case r := <-done:
+		return r.str, r.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
 }
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/sbreitf1/go-jcrypt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnmarshalMatrixConfig(t *testing.T) {
+	config := MatrixConfig{Host: "https://matrix.example.com", User: "jdoe", Pass: "secret"}
+	data, err := jcrypt.Marshal(&config, &jcrypt.Options{GetKeyHandler: jcrypt.StaticKey(key)})
+	require.NoError(t, err)
+	assert.False(t, isPlainMatrixConfig(data))
+
+	parsed, usedKey, err := unmarshalMatrixConfig(data, DefaultPrompter)
+	require.NoError(t, err)
+	assert.Equal(t, config, parsed)
+	assert.Equal(t, key, usedKey)
+}
+
+func TestUnmarshalPlainMatrixConfig(t *testing.T) {
+	data := []byte(`{"host":"https://matrix.example.com","user":"jdoe","pass":"secret"}`)
+	assert.True(t, isPlainMatrixConfig(data))
+
+	parsed, _, err := unmarshalMatrixConfig(data, DefaultPrompter)
+	require.NoError(t, err)
+	assert.Equal(t, MatrixConfig{Host: "https://matrix.example.com", User: "jdoe", Pass: "secret"}, parsed)
+}
+
+func TestMigrateProfileFile(t *testing.T) {
+	configDir := t.TempDir()
+	configFile := filepath.Join(configDir, "matrix.json")
+	config := MatrixConfig{Host: "https://matrix.example.com", User: "jdoe", Pass: "secret"}
+	store := FileCredentialStore{Dir: configDir}
+
+	// version 0 files have no version header
+	v0, err := jcrypt.Marshal(&config, &jcrypt.Options{GetKeyHandler: jcrypt.StaticKey(key)})
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(configFile, v0, configFilePerm))
+
+	loaded, ok, err := store.Load(DefaultProfile)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, config, loaded)
+
+	v1, err := ioutil.ReadFile(configFile)
+	require.NoError(t, err)
+	raw, err := readRawProfileData(v1)
+	require.NoError(t, err)
+	assert.Equal(t, float64(1), raw["version"])
+	assert.NotContains(t, string(v1), "secret")
+	parsed, _, err := unmarshalMatrixConfig(v1, DefaultPrompter)
+	require.NoError(t, err)
+	assert.Equal(t, config, parsed)
+
+	// current files are not written again
+	_, _, err = store.Load(DefaultProfile)
+	require.NoError(t, err)
+	data, err := ioutil.ReadFile(configFile)
+	require.NoError(t, err)
+	assert.Equal(t, v1, data)
+
+	// plain text passwords are encrypted during migration
+	require.NoError(t, ioutil.WriteFile(configFile, []byte(`{"host":"https://matrix.example.com","user":"jdoe","pass":"secret"}`), configFilePerm))
+	loaded, _, err = store.Load(DefaultProfile)
+	require.NoError(t, err)
+	assert.Equal(t, config, loaded)
+	data, err = ioutil.ReadFile(configFile)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "secret")
+	assert.Contains(t, string(data), `"version":1`)
+}
+
+func TestMigrateProfileDataUnsupported(t *testing.T) {
+	_, _, err := migrateProfileData([]byte(`{"version":2,"host":"https://matrix.example.com"}`))
+	assert.Error(t, err)
+	_, _, err = migrateProfileData([]byte(`{"version":"1","host":"https://matrix.example.com"}`))
+	assert.Error(t, err)
+	_, _, err = migrateProfileData([]byte(`{"version":0.5}`))
+	assert.Error(t, err)
+
+	data := []byte(`{"version":1,"host":"https://matrix.example.com"}`)
+	migrated, changed, err := migrateProfileData(data)
+	require.NoError(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, data, migrated)
+}
+
+type configDirCase struct {
+	Env         map[string]string
+	Home        string
+	Dir         string
+	ExpectError bool
+}
+
+func TestResolveConfigDir(t *testing.T) {
+	testCases := []configDirCase{
+		{Home: "/home/jdoe", Dir: "/home/jdoe/.gohome"},
+		{Env: map[string]string{"GOHOME_CONFIG_DIR": "/etc/gohome"}, Home: "/home/jdoe", Dir: "/etc/gohome"},
+		{Env: map[string]string{"XDG_CONFIG_HOME": "/tmp/config"}, Home: "/home/jdoe", Dir: "/home/jdoe/.gohome"},
+		{Env: map[string]string{"XDG_CONFIG_HOME": "/tmp/config"}, Dir: "/tmp/config/gohome"},
+		{ExpectError: true},
+	}
+
+	for i, c := range testCases {
+		t.Run(fmt.Sprintf("Test %d", i), func(t *testing.T) {
+			getenv := func(key string) string { return c.Env[key] }
+			homeDir := func() (string, error) {
+				if len(c.Home) == 0 {
+					return "", fmt.Errorf("no home")
+				}
+				return c.Home, nil
+			}
+
+			dir, err := resolveConfigDir(getenv, homeDir)
+			if c.ExpectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, c.Dir, dir)
+			}
+		})
+	}
+}
+
+func TestSetConfigDir(t *testing.T) {
+	defer func() { configDirOverride = "" }()
+
+	assert.True(t, errors.Is(SetConfigDir(" "), ErrNoConfigDir))
+
+	dir := t.TempDir()
+	require.NoError(t, SetConfigDir(dir))
+	configDir, err := getConfigDir()
+	require.NoError(t, err)
+	assert.Equal(t, dir, configDir)
+}
+
+type memoryCredentialStore map[string]MatrixConfig
+
+func (s memoryCredentialStore) Load(profile string) (MatrixConfig, bool, error) {
+	config, ok := s[profile]
+	return config, ok, nil
+}
+
+func (s memoryCredentialStore) Save(profile string, config MatrixConfig) error {
+	s[profile] = config
+	return nil
+}
+
+func TestConfig(t *testing.T) {
+	t.Setenv("GOHOME_HOST", "")
+	t.Setenv("GOHOME_USER", "")
+	t.Setenv("GOHOME_PASS", "")
+
+	_, err := Config{}.SessionCacheFile(DefaultProfile)
+	assert.True(t, errors.Is(err, ErrNoConfigDir))
+
+	dir := filepath.Join(t.TempDir(), "gohome")
+	store := memoryCredentialStore{"work": {Host: "https://matrix.example.com", User: "jdoe", Pass: "secret"}}
+	config := Config{Dir: dir, CredentialStore: store}
+
+	matrixConfig, err := config.MatrixConfigForProfile("work")
+	require.NoError(t, err)
+	assert.Equal(t, store["work"], matrixConfig)
+
+	file, err := config.SessionCacheFile("work")
+	require.NoError(t, err)
+	assert.Equal(t, dir, filepath.Dir(file))
+	assert.DirExists(t, dir)
+
+	require.NoError(t, config.SaveEntries([]Entry{come(8, 0)}))
+	entries, err := config.LoadHistory(tim(0, 0), tim(23, 59))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+// stubPrompter returns the given answers in order and records all labels.
+type stubPrompter struct {
+	Answers []string
+	Labels  []string
+}
+
+func (p *stubPrompter) PromptUser(label string) (string, error) {
+	return p.next(label)
+}
+
+func (p *stubPrompter) PromptPassword(label string) (string, error) {
+	return p.next(label)
+}
+
+func (p *stubPrompter) next(label string) (string, error) {
+	p.Labels = append(p.Labels, label)
+	if len(p.Answers) == 0 {
+		return "", fmt.Errorf("unexpected prompt %q", label)
+	}
+	answer := p.Answers[0]
+	p.Answers = p.Answers[1:]
+	return answer, nil
+}
+
+func TestConfigPrompter(t *testing.T) {
+	t.Setenv("GOHOME_HOST", "")
+	t.Setenv("GOHOME_USER", "")
+	t.Setenv("GOHOME_PASS", "")
+
+	store := memoryCredentialStore{}
+	prompter := &stubPrompter{Answers: []string{" ", "matrix.example.com", "jdoe", "secret"}}
+	config := Config{Dir: t.TempDir(), CredentialStore: store, Prompter: prompter}
+
+	matrixConfig, err := config.MatrixConfigForProfile("work")
+	require.NoError(t, err)
+	assert.Equal(t, MatrixConfig{Host: "https://matrix.example.com", User: "jdoe", Pass: "secret"}, matrixConfig)
+	assert.Equal(t, matrixConfig, store["work"])
+	assert.Equal(t, []string{"Matrix host", "Invalid host (host must not be empty), Matrix host", "Matrix user", "Matrix password"}, prompter.Labels)
+
+	_, err = Config{Dir: t.TempDir(), CredentialStore: memoryCredentialStore{}, Prompter: &stubPrompter{}}.MatrixConfigForProfile("work")
+	assert.Error(t, err)
+}
+
+func TestConfigProjectHost(t *testing.T) {
+	t.Setenv("GOHOME_HOST", "")
+	t.Setenv("GOHOME_USER", "")
+	// the password of the stored host must not be sent to the project host
+	t.Setenv("GOHOME_PASS", "envsecret")
+
+	project := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(project, projectHostFileName), []byte("matrix.acme.example.com\n"), 0600))
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(project))
+	defer os.Chdir(wd)
+
+	netrc := filepath.Join(t.TempDir(), ".netrc")
+	t.Setenv("NETRC", netrc)
+	store := memoryCredentialStore{DefaultProfile: {Host: "https://matrix.example.com", User: "jdoe", Pass: "secret"}}
+	config := Config{Dir: t.TempDir(), CredentialStore: store}
+
+	// the default entry is not used for the project host
+	require.NoError(t, ioutil.WriteFile(netrc, []byte("default login jdoe password guest\n"), 0600))
+	matrixConfig, err := config.MatrixConfigForProfile(DefaultProfile)
+	require.NoError(t, err)
+	assert.Equal(t, MatrixConfig{Host: "https://matrix.acme.example.com", User: "jdoe"}, matrixConfig)
+
+	require.NoError(t, ioutil.WriteFile(netrc, []byte("machine matrix.acme.example.com login jdoe password acme\ndefault login jdoe password guest\n"), 0600))
+	matrixConfig, err = config.MatrixConfigForProfile(DefaultProfile)
+	require.NoError(t, err)
+	assert.Equal(t, MatrixConfig{Host: "https://matrix.acme.example.com", User: "jdoe", Pass: "acme"}, matrixConfig)
+}
+
+func TestFileCredentialStorePrompter(t *testing.T) {
+	configDir := t.TempDir()
+	config := MatrixConfig{Host: "https://matrix.example.com", User: "jdoe", Pass: "secret"}
+
+	require.NoError(t, FileCredentialStore{Dir: configDir, Prompter: &stubPrompter{Answers: []string{"s3cret"}}}.Save(DefaultProfile, config))
+	loaded, ok, err := FileCredentialStore{Dir: configDir, Prompter: &stubPrompter{Answers: []string{"s3cret"}}}.Load(DefaultProfile)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, config, loaded)
+
+	_, _, err = FileCredentialStore{Dir: configDir, Prompter: &stubPrompter{Answers: []string{"wrong"}}}.Load(DefaultProfile)
+	assert.Error(t, err)
+
+	// setting a credential asks for the passphrase of the existing file
+	prompter := &stubPrompter{Answers: []string{"s3cret"}}
+	require.NoError(t, Config{Dir: configDir, Prompter: prompter}.SetCredential("matrix.example.com", "jane", "other"))
+	assert.Equal(t, []string{"Passphrase to decrypt Matrix configuration"}, prompter.Labels)
+}
+
+func TestGetProfileFile(t *testing.T) {
+	file, err := getProfileFile("/home/jdoe/.gohome", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "/home/jdoe/.gohome/matrix.json", file)
+
+	file, err = getProfileFile("/home/jdoe/.gohome", DefaultProfile)
+	assert.NoError(t, err)
+	assert.Equal(t, "/home/jdoe/.gohome/matrix.json", file)
+
+	file, err = getProfileFile("/home/jdoe/.gohome", "second-badge")
+	assert.NoError(t, err)
+	assert.Equal(t, "/home/jdoe/.gohome/matrix-second-badge.json", file)
+
+	_, err = getProfileFile("/home/jdoe/.gohome", "../evil")
+	assert.Error(t, err)
+}
+
+func TestMatrixConfigFromEnv(t *testing.T) {
+	env := map[string]string{"GOHOME_HOST": "https://matrix.example.com", "GOHOME_USER": "jdoe", "GOHOME_PASS": "secret"}
+	config, ok := matrixConfigFromEnv(func(key string) string { return env[key] })
+	assert.True(t, ok)
+	assert.Equal(t, MatrixConfig{Host: "https://matrix.example.com", User: "jdoe", Pass: "secret"}, config)
+
+	delete(env, "GOHOME_HOST")
+	_, ok = matrixConfigFromEnv(func(key string) string { return env[key] })
+	assert.False(t, ok)
+}
+
+type hostCase struct {
+	Input       string
+	Host        string
+	ExpectError bool
+}
+
+func TestNormalizeHost(t *testing.T) {
+	testCases := []hostCase{
+		{Input: "matrix.example.com", Host: "https://matrix.example.com"},
+		{Input: "  matrix.example.com  ", Host: "https://matrix.example.com"},
+		{Input: "https://matrix.example.com/", Host: "https://matrix.example.com"},
+		{Input: "HTTP://matrix.example.com:8080/matrix-v3.7.3.75487/login.jspx", Host: "http://matrix.example.com:8080"},
+		{Input: "10.0.0.1:8443", Host: "https://10.0.0.1:8443"},
+		{Input: "", ExpectError: true},
+		{Input: "https://", ExpectError: true},
+		{Input: "matrix.example.com:http", ExpectError: true},
+		{Input: "matrix.example.com:99999", ExpectError: true},
+		{Input: "matrix.example.com:", ExpectError: true},
+		{Input: "matrix example.com", ExpectError: true},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.Input, func(t *testing.T) {
+			host, err := normalizeHost(c.Input)
+			if c.ExpectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, c.Host, host)
+			}
+		})
+	}
+}
+
+func TestWritePrivateFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "matrix.json")
+	require.NoError(t, ioutil.WriteFile(file, []byte("old content that is longer"), 0644))
+
+	require.NoError(t, writePrivateFile(file, []byte("new")))
+	data, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(data))
+
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(file)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(configFilePerm), info.Mode().Perm())
+	}
+
+	// no temporary files are left behind
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestReadContext(t *testing.T) {
+	str, err := readContext(context.Background(), func() (string, error) { return "secret", nil })
+	require.NoError(t, err)
+	assert.Equal(t, "secret", str)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	release := make(chan struct{})
+	defer close(release)
+	_, err = readContext(ctx, func() (string, error) {
+		<-release
+		return "too late", nil
+	})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	_, err = readContext(ctx, func() (string, error) {
+		t.Error("read must not be called for a done context")
+		return "", nil
+	})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
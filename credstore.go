@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/sbreitf1/go-console"
+	"github.com/zalando/go-keyring"
+)
+
+const (
+	keyringService = "gohome"
+)
+
+var (
+	// DefaultCredentialStore is used to persist the Matrix configuration of profiles.
+	DefaultCredentialStore CredentialStore = FileCredentialStore{}
+)
+
+// CredentialStore persists the Matrix configuration of profiles.
+type CredentialStore interface {
+	// Load returns the stored configuration of a profile. The second return value is false if nothing is stored for the profile.
+	Load(profile string) (MatrixConfig, bool, error)
+	// Save stores the configuration of a profile.
+	Save(profile string, config MatrixConfig) error
+}
+
+// FileCredentialStore stores configurations as JSON files with encrypted password in the config directory.
+type FileCredentialStore struct {
+	// Dir is the directory of the configuration files. The default config directory is used if empty.
+	Dir string
+	// Prompter asks for passphrases. DefaultPrompter is used if nil.
+	Prompter Prompter
+}
+
+func (s FileCredentialStore) dir() (string, error) {
+	if len(s.Dir) > 0 {
+		return s.Dir, nil
+	}
+	return getConfigDir()
+}
+
+// Load reads the configuration file of a profile and asks for the passphrase if required. Files written by older versions are upgraded to the current format.
+func (s FileCredentialStore) Load(profile string) (MatrixConfig, bool, error) {
+	configDir, err := s.dir()
+	if err != nil {
+		return MatrixConfig{}, false, err
+	}
+	configFile, err := getProfileFile(configDir, profile)
+	if err != nil {
+		return MatrixConfig{}, false, err
+	}
+
+	data, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return MatrixConfig{}, false, nil
+		}
+		return MatrixConfig{}, false, err
+	}
+
+	warnPermissiveMode(configDir)
+	warnPermissiveMode(configFile)
+
+	data, migrated, err := migrateProfileData(data)
+	if err != nil {
+		return MatrixConfig{}, false, err
+	}
+	config, passphrase, err := unmarshalMatrixConfig(data, orDefaultPrompter(s.Prompter))
+	if err != nil {
+		return MatrixConfig{}, false, err
+	}
+
+	if migrated || isPlainMatrixConfig(data) {
+		// configurations written by hand or by older versions might lack the version header or contain the password in plain text
+		if err := writeMatrixConfig(configFile, config, passphrase); err != nil {
+			console.Printlnf("Failed to update configuration: %s", err.Error())
+		}
+	}
+
+	return config, true, nil
+}
+
+// Save writes the configuration file of a profile. The user is asked for an optional passphrase to protect the password.
+func (s FileCredentialStore) Save(profile string, config MatrixConfig) error {
+	configDir, err := s.dir()
+	if err != nil {
+		return err
+	}
+	configFile, err := getProfileFile(configDir, profile)
+	if err != nil {
+		return err
+	}
+
+	passphrase := key
+	if len(config.Pass) > 0 {
+		str, err := orDefaultPrompter(s.Prompter).PromptPassword("Passphrase to protect the stored password, leave empty to skip")
+		if err != nil {
+			return err
+		}
+		if len(str) > 0 {
+			passphrase = []byte(str)
+		}
+	}
+
+	if err := os.MkdirAll(configDir, configDirPerm); err != nil {
+		return err
+	}
+	return writeMatrixConfig(configFile, config, passphrase)
+}
+
+// KeyringCredentialStore stores configurations in the secret store of the operating system like macOS Keychain, Secret Service on Linux or the Windows Credential Manager.
+type KeyringCredentialStore struct{}
+
+// Load reads the configuration of a profile from the secret store.
+func (KeyringCredentialStore) Load(profile string) (MatrixConfig, bool, error) {
+	data, err := keyring.Get(keyringService, keyringUser(profile))
+	if err != nil {
+		if err == keyring.ErrNotFound {
+			return MatrixConfig{}, false, nil
+		}
+		return MatrixConfig{}, false, err
+	}
+
+	var config MatrixConfig
+	if err := json.Unmarshal([]byte(data), &config); err != nil {
+		return MatrixConfig{}, false, err
+	}
+	return config, true, nil
+}
+
+// Save writes the configuration of a profile to the secret store.
+func (KeyringCredentialStore) Save(profile string, config MatrixConfig) error {
+	data, err := json.Marshal(&config)
+	if err != nil {
+		return err
+	}
+	return keyring.Set(keyringService, keyringUser(profile), string(data))
+}
+
+func keyringUser(profile string) string {
+	if len(profile) == 0 {
+		return DefaultProfile
+	}
+	return profile
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/term"
+)
+
+const (
+	icsTimeFormat = "20060102T150405Z"
+)
+
+// MarshalEntries writes all entries as JSON array to w.
+func MarshalEntries(entries []Entry, w io.Writer) error {
+	if entries == nil {
+		entries = []Entry{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(entries)
+}
+
+// TimeEntry is a completed work session in the format of time tracking services like Harvest or Toggl.
+type TimeEntry struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	// Hours is the duration of the session in hours rounded to two decimal places.
+	Hours float64 `json:"hours"`
+}
+
+// TimeEntries returns all completed work sessions as time entries. See WorkTimeOptions.TimeEntries for rounding.
+func TimeEntries(entries []Entry) ([]TimeEntry, error) {
+	return WorkTimeOptions{}.TimeEntries(entries)
+}
+
+// TimeEntries returns all completed work sessions as time entries with start and end rounded. Business trips do not interrupt a session and an open session is skipped.
+func (o WorkTimeOptions) TimeEntries(entries []Entry) ([]TimeEntry, error) {
+	sessions, err := workSessions(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	timeEntries := make([]TimeEntry, 0, len(sessions))
+	for _, s := range sessions {
+		if s.Open {
+			continue
+		}
+		start, end := o.roundTime(s.Start), o.roundTime(s.End)
+		hours := math.Round(end.Sub(start).Hours()*100) / 100
+		timeEntries = append(timeEntries, TimeEntry{Start: start, End: end, Hours: hours})
+	}
+	return timeEntries, nil
+}
+
+// MarshalTimeEntries writes all time entries as JSON array to w like [{"start":"2019-11-01T08:00:00+01:00","end":"2019-11-01T12:00:00+01:00","hours":4}].
+func MarshalTimeEntries(timeEntries []TimeEntry, w io.Writer) error {
+	if timeEntries == nil {
+		timeEntries = []TimeEntry{}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(timeEntries)
+}
+
+// ExportICS writes all completed work sessions as iCalendar events to w. Times are written in UTC so that calendar applications show them in the local time zone of the viewer. An open session is skipped.
+func ExportICS(entries []Entry, w io.Writer) error {
+	sessions, err := workSessions(entries)
+	if err != nil {
+		return err
+	}
+
+	stamp := time.Now().UTC().Format(icsTimeFormat)
+
+	bw := bufio.NewWriter(w)
+	// iCalendar requires CRLF line endings
+	fmt.Fprint(bw, "BEGIN:VCALENDAR\r\n")
+	fmt.Fprint(bw, "VERSION:2.0\r\n")
+	fmt.Fprint(bw, "PRODID:-//sbreitf1//gohome//EN\r\n")
+	for _, s := range sessions {
+		if s.Open {
+			continue
+		}
+		fmt.Fprint(bw, "BEGIN:VEVENT\r\n")
+		fmt.Fprintf(bw, "UID:%d-%d@gohome\r\n", s.Start.Unix(), s.End.Unix())
+		fmt.Fprintf(bw, "DTSTAMP:%s\r\n", stamp)
+		fmt.Fprintf(bw, "DTSTART:%s\r\n", s.Start.UTC().Format(icsTimeFormat))
+		fmt.Fprintf(bw, "DTEND:%s\r\n", s.End.UTC().Format(icsTimeFormat))
+		fmt.Fprint(bw, "SUMMARY:At work\r\n")
+		fmt.Fprint(bw, "END:VEVENT\r\n")
+	}
+	fmt.Fprint(bw, "END:VCALENDAR\r\n")
+	return bw.Flush()
+}
+
+// RenderTable writes all entries as aligned table with columns for date, time and type to w. Every day ends with a line containing the worked time of that day, or "?" if the entries of the day cannot be evaluated, e.g. because of an unmatched pause.
+// Entry types are colored if w is a terminal.
+func RenderTable(entries []Entry, w io.Writer) error {
+	f, ok := w.(*os.File)
+	colored := ok && term.IsTerminal(int(f.Fd()))
+	return renderTable(entries, w, colored, time.Now())
+}
+
+func renderTable(entries []Entry, w io.Writer, colored bool, now time.Time) error {
+	worked := workedPerDay(entries, now)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DATE\tTIME\tTYPE")
+	for i, entry := range entries {
+		day := startOfDay(entry.Time)
+		date := ""
+		if i == 0 || !startOfDay(entries[i-1].Time).Equal(day) {
+			date = day.Format("2006-01-02")
+		}
+
+		// the color is only applied to the last column, so escape sequences do not break the alignment
+		entryType := string(entry.Type)
+		if color := entryTypeColor(entry.Type); colored && len(color) > 0 {
+			entryType = color + entryType + colorEnd
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", date, entry.Time.Format("15:04"), entryType)
+
+		if i == len(entries)-1 || !startOfDay(entries[i+1].Time).Equal(day) {
+			total := "?"
+			if duration, ok := worked[day]; ok {
+				total = formatDurationMinutes(duration)
+			}
+			fmt.Fprintf(tw, "\ttotal\t%s\n", total)
+		}
+	}
+	return tw.Flush()
+}
+
+// workedPerDay returns the worked time of every day. Days with entries that cannot be evaluated are missing in the result.
+func workedPerDay(entries []Entry, now time.Time) map[time.Time]time.Duration {
+	worked := make(map[time.Time]time.Duration)
+	summaries, err := buildDaySummaries(entries, now)
+	if err != nil {
+		// evaluate every day on its own, so only the days with invalid entries lack a total
+		summaries = make([]DaySummary, 0)
+		for _, dayEntries := range GroupByDay(entries) {
+			if daySummaries, err := buildDaySummaries(dayEntries, now); err == nil {
+				summaries = append(summaries, daySummaries...)
+			}
+		}
+	}
+	for _, summary := range summaries {
+		worked[summary.Date] = summary.Worked
+	}
+	return worked
+}
+
+// entryTypeColor returns the color used to print entries of the given type in the terminal.
+func entryTypeColor(entryType EntryType) string {
+	switch entryType {
+	case EntryTypeCome:
+		return colorComeEntry
+	case EntryTypeLeave:
+		return colorLeaveEntry
+	case EntryTypeTrip:
+		return colorTripEntry
+	case EntryTypePauseStart, EntryTypePauseEnd:
+		return colorBreakEntry
+	}
+	return ""
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportICS(t *testing.T) {
+	loc := time.FixedZone("CET", 3600)
+	entries := []Entry{
+		{Type: EntryTypeCome, Time: time.Date(2019, time.November, 1, 8, 0, 0, 0, loc)},
+		{Type: EntryTypeLeave, Time: time.Date(2019, time.November, 1, 12, 0, 0, 0, loc)},
+		{Type: EntryTypeCome, Time: time.Date(2019, time.November, 1, 12, 30, 0, 0, loc)},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, ExportICS(entries, &buf))
+
+	ics := buf.String()
+	assert.True(t, strings.HasPrefix(ics, "BEGIN:VCALENDAR\r\n"))
+	assert.True(t, strings.HasSuffix(ics, "END:VCALENDAR\r\n"))
+	assert.Equal(t, 1, strings.Count(ics, "BEGIN:VEVENT"))
+	assert.Contains(t, ics, "DTSTART:20191101T070000Z\r\n")
+	assert.Contains(t, ics, "DTEND:20191101T110000Z\r\n")
+	assert.Contains(t, ics, "SUMMARY:At work\r\n")
+}
+
+func TestRenderTable(t *testing.T) {
+	day := func(d, hours, minutes int) time.Time {
+		return time.Date(2019, time.November, d, hours, minutes, 0, 0, time.UTC)
+	}
+	entries := []Entry{
+		{Type: EntryTypeCome, Time: day(4, 8, 0)},
+		{Type: EntryTypeLeave, Time: day(4, 12, 0)},
+		{Type: EntryTypeCome, Time: day(4, 12, 30)},
+		{Type: EntryTypeLeave, Time: day(4, 17, 0)},
+		{Type: EntryTypeCome, Time: day(5, 9, 0)},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, renderTable(entries, &buf, false, day(5, 10, 15)))
+	assert.Equal(t, "DATE        TIME   TYPE\n"+
+		"2019-11-04  08:00  come\n"+
+		"            12:00  leave\n"+
+		"            12:30  come\n"+
+		"            17:00  leave\n"+
+		"            total  08:30\n"+
+		"2019-11-05  09:00  come\n"+
+		"            total  01:15\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, renderTable(entries, &buf, true, day(5, 10, 15)))
+	assert.Contains(t, buf.String(), "08:00  "+colorComeEntry+"come"+colorEnd+"\n")
+	assert.Contains(t, buf.String(), "12:00  "+colorLeaveEntry+"leave"+colorEnd+"\n")
+
+	// a buffer is no terminal
+	buf.Reset()
+	require.NoError(t, RenderTable(entries[:2], &buf))
+	assert.NotContains(t, buf.String(), "\033[")
+
+	// days that cannot be evaluated are rendered without total
+	buf.Reset()
+	require.NoError(t, renderTable([]Entry{
+		{Type: EntryTypeCome, Time: day(4, 8, 0)},
+		{Type: EntryTypePauseEnd, Time: day(4, 12, 0)},
+		{Type: EntryTypeCome, Time: day(5, 9, 0)},
+		{Type: EntryTypeLeave, Time: day(5, 10, 0)},
+	}, &buf, false, day(5, 10, 15)))
+	assert.Equal(t, "DATE        TIME   TYPE\n"+
+		"2019-11-04  08:00  come\n"+
+		"            12:00  pause-end\n"+
+		"            total  ?\n"+
+		"2019-11-05  09:00  come\n"+
+		"            10:00  leave\n"+
+		"            total  01:00\n", buf.String())
+}
+
+func TestTimeEntries(t *testing.T) {
+	loc := time.FixedZone("CET", 3600)
+	at := func(hours, minutes int) time.Time {
+		return time.Date(2019, time.November, 1, hours, minutes, 0, 0, loc)
+	}
+	entries := []Entry{
+		{Type: EntryTypeCome, Time: at(8, 2)},
+		{Type: EntryTypeLeave, Time: at(12, 1)},
+		{Type: EntryTypeCome, Time: at(12, 40)},
+		{Type: EntryTypeLeave, Time: at(16, 0)},
+		{Type: EntryTypeCome, Time: at(17, 0)},
+	}
+
+	timeEntries, err := TimeEntries(entries)
+	require.NoError(t, err)
+	assert.Equal(t, []TimeEntry{
+		{Start: at(8, 2), End: at(12, 1), Hours: 3.98},
+		{Start: at(12, 40), End: at(16, 0), Hours: 3.33},
+	}, timeEntries)
+
+	timeEntries, err = WorkTimeOptions{Rounding: 15 * time.Minute}.TimeEntries(entries)
+	require.NoError(t, err)
+	assert.Equal(t, TimeEntry{Start: at(8, 0), End: at(12, 0), Hours: 4}, timeEntries[0])
+	assert.Equal(t, TimeEntry{Start: at(12, 45), End: at(16, 0), Hours: 3.25}, timeEntries[1])
+
+	var buf bytes.Buffer
+	require.NoError(t, MarshalTimeEntries(timeEntries[:1], &buf))
+	assert.JSONEq(t, `[{"start":"2019-11-01T08:00:00+01:00","end":"2019-11-01T12:00:00+01:00","hours":4}]`, buf.String())
+
+	buf.Reset()
+	require.NoError(t, MarshalTimeEntries(nil, &buf))
+	assert.JSONEq(t, `[]`, buf.String())
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	maxConcurrentFetches = 4
+)
+
+// HostError contains the errors of all accounts that failed during FetchAll by Account. Like errors joined by errors.Join, errors.Is and errors.As check the errors of all accounts.
+type HostError map[string]error
+
+func (e HostError) Error() string {
+	hosts := e.hosts()
+	messages := make([]string, len(hosts))
+	for i, host := range hosts {
+		messages[i] = fmt.Sprintf("%s: %s", host, e[host].Error())
+	}
+	return "fetch failed for " + strings.Join(messages, "; ")
+}
+
+// Unwrap returns the errors of all accounts ordered by Account.
+func (e HostError) Unwrap() []error {
+	hosts := e.hosts()
+	errs := make([]error, len(hosts))
+	for i, host := range hosts {
+		errs[i] = e[host]
+	}
+	return errs
+}
+
+func (e HostError) hosts() []string {
+	hosts := make([]string, 0, len(e))
+	for host := range e {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// Account returns the key of the account in the results of FetchAll in the form user@host, so multiple accounts on the same host are kept apart.
+func (c MatrixConfig) Account() string {
+	return c.User + "@" + c.Host
+}
+
+// FetchAll returns today's entries of multiple Matrix accounts by Account. The accounts are fetched concurrently. See FetchAllContext for the handling of failed accounts.
+func FetchAll(specs []MatrixConfig) (map[string][]Entry, error) {
+	return FetchAllContext(context.Background(), specs, FetchOptions{})
+}
+
+// FetchAllContext is like FetchAll but aborts all pending requests when ctx is cancelled. A failing account does not abort the other fetches.
+// The returned error is a HostError if any account failed. The result is partial in this case and contains all accounts with entries, including accounts that returned entries together with RowErrors for skipped rows.
+func FetchAllContext(ctx context.Context, specs []MatrixConfig, options FetchOptions) (map[string][]Entry, error) {
+	var mutex sync.Mutex
+	results := make(map[string][]Entry)
+	errs := make(HostError)
+
+	jobs := make(chan MatrixConfig)
+	var wg sync.WaitGroup
+	for i := 0; i < maxConcurrentFetches && i < len(specs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for config := range jobs {
+				entries, _, err := FetchMatrixEntriesContext(ctx, config, options)
+
+				mutex.Lock()
+				if entries != nil {
+					results[config.Account()] = entries
+				}
+				if err != nil {
+					errs[config.Account()] = err
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for _, config := range specs {
+		jobs <- config
+	}
+	close(jobs)
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return results, errs
+	}
+	return results, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchAllCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	results, err := FetchAllContext(ctx, []MatrixConfig{
+		{Host: "https://a.example.com", User: "a"},
+		{Host: "https://b.example.com", User: "b"},
+	}, FetchOptions{})
+	require.Error(t, err)
+	assert.Empty(t, results)
+
+	var hostErr HostError
+	require.True(t, errors.As(err, &hostErr))
+	assert.Len(t, hostErr, 2)
+	assert.True(t, errors.Is(hostErr["a@https://a.example.com"], context.Canceled))
+}
+
+func TestHostErrorMessage(t *testing.T) {
+	err := HostError{
+		"https://b.example.com": errors.New("timeout"),
+		"https://a.example.com": ErrAuthFailed,
+	}
+	assert.Equal(t, "fetch failed for https://a.example.com: authentication failed; https://b.example.com: timeout", err.Error())
+}
+
+func TestFetchAllPartial(t *testing.T) {
+	good := newMockMatrix(t)
+	bad := newMockMatrix(t)
+	badConfig := bad.Config()
+	badConfig.Pass = "wrong"
+
+	results, err := FetchAllContext(context.Background(), []MatrixConfig{good.Config(), badConfig}, FetchOptions{})
+	require.Error(t, err)
+	assert.Len(t, results, 1)
+	assert.Len(t, results[good.Config().Account()], 3)
+
+	assert.True(t, errors.Is(err, ErrAuthFailed))
+	var hostErr HostError
+	require.True(t, errors.As(err, &hostErr))
+	assert.Len(t, hostErr, 1)
+	assert.Contains(t, hostErr, badConfig.Account())
+}
+
+func TestFetchAllSameHost(t *testing.T) {
+	m := newMockMatrix(t)
+	other := m.Config()
+	other.User = "mmuster"
+
+	results, err := FetchAllContext(context.Background(), []MatrixConfig{m.Config(), other}, FetchOptions{})
+	require.Error(t, err)
+	assert.Len(t, results, 1)
+	assert.Len(t, results["jdoe@"+m.URL], 3)
+
+	var hostErr HostError
+	require.True(t, errors.As(err, &hostErr))
+	assert.Len(t, hostErr, 1)
+	assert.True(t, errors.Is(hostErr["mmuster@"+m.URL], ErrAuthFailed))
+}
+
+func TestHostErrorUnwrap(t *testing.T) {
+	err := error(HostError{
+		"https://b.example.com": context.DeadlineExceeded,
+		"https://a.example.com": ErrAuthFailed,
+	})
+	assert.True(t, errors.Is(err, ErrAuthFailed))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	assert.False(t, errors.Is(err, ErrServerUnavailable))
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	historyFileName = "history.jsonl"
+)
+
+// SaveEntries appends all entries to the local history using DefaultConfig. See Config.SaveEntries for details.
+func SaveEntries(entries []Entry) error {
+	config, err := DefaultConfig()
+	if err != nil {
+		return err
+	}
+	return config.SaveEntries(entries)
+}
+
+// LoadHistory returns all entries of the local history using DefaultConfig. See Config.LoadHistory for details.
+func LoadHistory(from, to time.Time) ([]Entry, error) {
+	config, err := DefaultConfig()
+	if err != nil {
+		return nil, err
+	}
+	return config.LoadHistory(from, to)
+}
+
+// SaveEntries appends all entries to the local history that are not stored yet. Entries are identified by time and type like in MergeEntries, so saving overlapping fetches multiple times does not create duplicates.
+func (c Config) SaveEntries(entries []Entry) error {
+	configDir, err := c.createDir()
+	if err != nil {
+		return err
+	}
+	return appendHistory(filepath.Join(configDir, historyFileName), entries)
+}
+
+// LoadHistory returns all entries of the local history with from <= time < to in chronological order.
+func (c Config) LoadHistory(from, to time.Time) ([]Entry, error) {
+	configDir, err := c.dir()
+	if err != nil {
+		return nil, err
+	}
+	return readHistory(filepath.Join(configDir, historyFileName), from, to)
+}
+
+// appendHistory writes all entries to file as JSON lines that are not already contained.
+func appendHistory(file string, entries []Entry) error {
+	existing, err := readAllHistory(file)
+	if err != nil {
+		return err
+	}
+	known := make(map[entryKey]bool, len(existing))
+	for _, entry := range existing {
+		known[newEntryKey(entry)] = true
+	}
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, configFilePerm)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	for _, entry := range entries {
+		k := newEntryKey(entry)
+		if known[k] {
+			continue
+		}
+		known[k] = true
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// readHistory returns all entries of file with from <= time < to in chronological order.
+func readHistory(file string, from, to time.Time) ([]Entry, error) {
+	all, err := readAllHistory(file)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0)
+	for _, entry := range all {
+		if !entry.Time.Before(from) && entry.Time.Before(to) {
+			entries = append(entries, entry)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+	return entries, nil
+}
+
+// readAllHistory returns all entries of file in the stored order. A missing file is treated as empty history.
+func readAllHistory(file string) ([]Entry, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := make([]Entry, 0)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("invalid history entry in line %d: %s", line, err.Error())
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
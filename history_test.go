@@ -0,0 +1,46 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistory(t *testing.T) {
+	file := filepath.Join(t.TempDir(), historyFileName)
+
+	require.NoError(t, appendHistory(file, []Entry{come(8, 0), leave(12, 0)}))
+	// overlapping fetch of the same day must not duplicate entries
+	require.NoError(t, appendHistory(file, []Entry{come(8, 0), leave(12, 0), come(12, 30)}))
+	// the same instant in another time zone is still the same entry
+	require.NoError(t, appendHistory(file, []Entry{{Type: EntryTypeCome, Time: tim(12, 30).In(time.FixedZone("CET", 3600))}}))
+
+	data, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	assert.Equal(t, 3, strings.Count(string(data), "\n"))
+
+	entries, err := readHistory(file, tim(0, 0), tim(12, 30))
+	require.NoError(t, err)
+	for i := range entries {
+		entries[i].Time = entries[i].Time.UTC()
+	}
+	assert.Equal(t, []Entry{come(8, 0), leave(12, 0)}, entries)
+}
+
+func TestHistoryMissingFile(t *testing.T) {
+	entries, err := readHistory(filepath.Join(t.TempDir(), historyFileName), tim(0, 0), tim(23, 0))
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	// loading must not create the config directory
+	configDir := filepath.Join(t.TempDir(), "gohome")
+	entries, err = Config{Dir: configDir}.LoadHistory(tim(0, 0), tim(23, 0))
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+	assert.NoDirExists(t, configDir)
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"log"
 	"os"
 	"time"
 
@@ -16,6 +20,27 @@ var (
 	argTargetTime = appMain.Flag("target-time", "Your daily target time like '08:00'").Default("08:00").Short('t').String()
 	argBreakTime  = appMain.Flag("break", "Ignore actual break time and take input like '00:45' instead").Short('b').String()
 	argReminder   = appMain.Flag("reminder", "show desktop notification on target time").Short('r').Bool()
+	argTimeout    = appMain.Flag("timeout", "Maximum duration of a single request to Matrix").Default("30s").Duration()
+	argJSON       = appMain.Flag("json", "Print today's entries as JSON array instead of statistics").Bool()
+	argTable      = appMain.Flag("table", "Print today's entries as table instead of statistics").Bool()
+	argProfile    = appMain.Flag("profile", "Name of the Matrix account profile to use").Short('p').Default(DefaultProfile).String()
+	argDumpHTML   = appMain.Flag("dump-html", "Write the raw booking page to a file for debugging").String()
+	argTrace      = appMain.Flag("trace", "Write all requests and responses to a file for bug reports, credentials are redacted").String()
+	argRetries    = appMain.Flag("retries", "Number of retries on server or network errors").Default("0").Int()
+	argProxy      = appMain.Flag("proxy", "Proxy URL like 'http://proxy:3128' or 'socks5://localhost:1080', defaults to HTTP_PROXY/HTTPS_PROXY").String()
+	argVerifyTLS  = appMain.Flag("verify-tls", "Verify the TLS certificate of the Matrix host").Bool()
+	argCACert     = appMain.Flag("ca-cert", "PEM file with trusted CA certificates for the Matrix host, implies --verify-tls").String()
+	argServerName = appMain.Flag("tls-server-name", "Name to verify the certificate of the Matrix host against, implies --verify-tls").String()
+	argVerbose    = appMain.Flag("verbose", "Print debug messages about the communication with Matrix to stderr").Short('v').Bool()
+	argReuse      = appMain.Flag("reuse-session", "Keep the Matrix session open and reuse it in following runs").Bool()
+	argResetSess  = appMain.Flag("reset-session", "Discard a cached Matrix session before fetching").Bool()
+	argKeyring    = appMain.Flag("keyring", "Store the Matrix configuration in the secret store of the operating system").Bool()
+	argReset      = appMain.Flag("reset", "Delete cached sessions and the stored password for a Matrix host and exit").String()
+	argResetAll   = appMain.Flag("reset-all", "Delete all profiles, cached sessions and the history and exit").Bool()
+)
+
+const (
+	maxLoginAttempts = 3
 )
 
 const (
@@ -77,23 +102,107 @@ func process() error {
 		//TODO check target time
 	}
 
-	matrixConfig, err := GetMatrixConfig()
+	if *argResetAll {
+		if err := ResetAll(); err != nil {
+			return fmt.Errorf("unable to reset configuration: %s", err.Error())
+		}
+		return nil
+	}
+	if len(*argReset) > 0 {
+		if err := Reset(*argReset); err != nil {
+			return fmt.Errorf("unable to reset host: %s", err.Error())
+		}
+		return nil
+	}
+
+	if *argKeyring {
+		DefaultCredentialStore = KeyringCredentialStore{}
+	}
+	matrixConfig, err := GetMatrixConfigForProfile(*argProfile)
 	if err != nil {
 		return fmt.Errorf("unable to retrieve Matrix configuration: %s", err.Error())
 	}
 
 	if len(matrixConfig.Pass) == 0 {
-		console.Println("Please enter Matrix password (it will not be stored locally):")
-		console.Print("> ")
-		matrixConfig.Pass, err = console.ReadPassword()
+		matrixConfig.Pass, err = DefaultPrompter.PromptPassword("Matrix password (it will not be stored locally)")
 		if err != nil {
 			return fmt.Errorf("unable to retrieve Matrix password: %s", err.Error())
 		}
 	}
 
-	entries, flexiTimeBalance, err := FetchMatrixEntries(matrixConfig)
+	fetchOptions := FetchOptions{
+		Timeout:  *argTimeout,
+		Retry:    RetryPolicy{MaxAttempts: *argRetries + 1},
+		ProxyURL: *argProxy,
+		// certificates are not verified by default to support self-signed hosts
+		InsecureSkipVerify: !*argVerifyTLS && len(*argCACert) == 0 && len(*argServerName) == 0,
+		CACertFile:         *argCACert,
+		TLSServerName:      *argServerName,
+	}
+	if *argVerbose {
+		fetchOptions.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	if len(*argTrace) > 0 {
+		traceFile, err := os.OpenFile(*argTrace, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, configFilePerm)
+		if err != nil {
+			return fmt.Errorf("unable to create trace file: %s", err.Error())
+		}
+		defer traceFile.Close()
+		fetchOptions.Trace = traceFile
+	}
+	if *argReuse || *argResetSess {
+		sessionFile, err := GetSessionCacheFile(*argProfile)
+		if err != nil {
+			return fmt.Errorf("unable to locate session cache: %s", err.Error())
+		}
+		if *argResetSess {
+			if err := InvalidateSessionCache(sessionFile); err != nil {
+				return fmt.Errorf("unable to discard cached session: %s", err.Error())
+			}
+		}
+		if *argReuse {
+			fetchOptions.SessionCacheFile = sessionFile
+		}
+	}
+	var entries []Entry
+	var flexiTimeBalance time.Duration
+	var rawHTML string
+	for attempt := 1; ; attempt++ {
+		entries, flexiTimeBalance, rawHTML, err = FetchMatrixEntriesRaw(context.Background(), matrixConfig, fetchOptions)
+		if errors.Is(err, ErrSessionExpired) && attempt < maxLoginAttempts {
+			// a cached session might have expired in the meantime, so login again
+			if len(fetchOptions.SessionCacheFile) > 0 {
+				InvalidateSessionCache(fetchOptions.SessionCacheFile)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrAuthFailed) || attempt >= maxLoginAttempts {
+			break
+		}
+
+		matrixConfig.Pass, err = DefaultPrompter.PromptPassword("Login failed, Matrix password")
+		if err != nil {
+			return fmt.Errorf("unable to retrieve Matrix password: %s", err.Error())
+		}
+	}
+	if len(*argDumpHTML) > 0 && len(rawHTML) > 0 {
+		if err := ioutil.WriteFile(*argDumpHTML, []byte(rawHTML), 0600); err != nil {
+			console.Printlnf("Failed to write booking page: %s", err.Error())
+		}
+	}
 	if err != nil {
-		return err
+		if entries == nil {
+			return err
+		}
+		// some booking rows have been skipped, but the remaining entries are still useful. Print to stderr to keep --json output valid
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", err.Error())
+	}
+
+	if *argJSON {
+		return MarshalEntries(entries, os.Stdout)
+	}
+	if *argTable {
+		return RenderTable(entries, os.Stdout)
 	}
 
 	if len(entries) > 0 {
@@ -115,6 +224,10 @@ func process() error {
 				console.Printlnf(" %s<-- %s%s", colorLeaveEntry, entry.Time.Format("15:04"), colorEnd)
 			} else if entry.Type == EntryTypeTrip {
 				console.Printlnf(" %s<-- %s DG%s", colorTripEntry, entry.Time.Format("15:04"), colorEnd)
+			} else if entry.Type == EntryTypePauseStart {
+				console.Printlnf(" %s<-- %s P%s", colorBreakEntry, entry.Time.Format("15:04"), colorEnd)
+			} else if entry.Type == EntryTypePauseEnd {
+				console.Printlnf(" %s--> %s P%s", colorBreakEntry, entry.Time.Format("15:04"), colorEnd)
 			}
 		}
 
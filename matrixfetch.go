@@ -1,15 +1,24 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"html"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"net/http/cookiejar"
 	"net/url"
+	"path"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
+
+	"golang.org/x/net/html/charset"
 )
 
 const (
@@ -20,27 +29,220 @@ const (
 	urlMatrixLogout                = "TODO"
 
 	matrixDebugPrint = false
+
+	defaultTimeout     = 30 * time.Second
+	defaultMaxBodySize = 8 << 20
+	pingTimeout        = 5 * time.Second
+
+	// statusSnippetReadSize and statusSnippetLength limit the part of an unexpected response that is shown in errors.
+	statusSnippetReadSize = 1024
+	statusSnippetLength   = 120
+)
+
+var (
+	// Version is the version of gohome and part of the default user agent. It is set at build time using -ldflags "-X main.Version=1.2.3".
+	Version = "dev"
+
+	patternMetaRefresh = regexp.MustCompile(`(?i)<meta\s+http-equiv="refresh"\s+content="(\d+)\s*;\s*url=([^"]*)"`)
+
+	// ErrAuthFailed is returned when Matrix rejects the credentials.
+	ErrAuthFailed = fmt.Errorf("authentication failed")
+	// ErrServerUnavailable is returned when Matrix responds with a server error.
+	ErrServerUnavailable = fmt.Errorf("server unavailable")
+	// ErrSessionExpired is returned when Matrix shows the login page instead of the requested page. A new client has to be created to login again.
+	ErrSessionExpired = fmt.Errorf("session expired")
+	// ErrClientClosed is returned when using a MatrixClient after Close has been called.
+	ErrClientClosed = fmt.Errorf("client closed")
+	// ErrBodyTooLarge is returned when a page exceeds FetchOptions.MaxBodySize.
+	ErrBodyTooLarge = fmt.Errorf("response body too large")
+	// ErrRedirectedOffHost is returned when Matrix redirects to another host, e.g. to a single sign-on page of a reverse proxy.
+	ErrRedirectedOffHost = fmt.Errorf("redirected to another host")
 )
 
 // FetchMatrixEntries returns today's entries available in "Aktuelle Buchungen" in Matrix and the current flexitime balance.
+// All fetching functions only use the given config and never read or write the config directory or prompt the user, unless SessionCacheFile is set. Use GetMatrixConfig to acquire the configuration like the command line tool.
 func FetchMatrixEntries(config MatrixConfig) ([]Entry, time.Duration, error) {
-	client, err := NewMatrixClient(config)
+	return FetchMatrixEntriesContext(context.Background(), config, FetchOptions{})
+}
+
+// FetchMatrixEntriesContext is like FetchMatrixEntries but aborts all pending requests when ctx is cancelled.
+func FetchMatrixEntriesContext(ctx context.Context, config MatrixConfig, options FetchOptions) ([]Entry, time.Duration, error) {
+	entries, flexitime, _, err := FetchMatrixEntriesRaw(ctx, config, options)
+	return entries, flexitime, err
+}
+
+// FetchMatrixEntriesRaw is like FetchMatrixEntriesContext but also returns the raw HTML of the booking page for debugging. The page is returned even if parsing failed.
+// Skipped booking rows are returned as RowErrors together with all other entries unless StrictParse is set.
+func FetchMatrixEntriesRaw(ctx context.Context, config MatrixConfig, options FetchOptions) ([]Entry, time.Duration, string, error) {
+	client, err := NewMatrixClientContext(ctx, config, options)
 	if err != nil {
-		return nil, 0, err
+		if ctx.Err() != nil {
+			return nil, 0, "", ctx.Err()
+		}
+		return nil, 0, "", err
 	}
 	defer client.Close()
 
-	entries, err := client.GetEntries()
+	entries, body, parseErr := client.GetEntriesRaw()
+	if entries == nil {
+		if ctx.Err() != nil {
+			return nil, 0, body, ctx.Err()
+		}
+		return nil, 0, body, fmt.Errorf("failed to retrieve entries: %w", parseErr)
+	}
+
+	flexitime, err := client.GetFlexiTime()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, 0, body, ctx.Err()
+		}
+		return nil, 0, body, fmt.Errorf("could not retrieve flexitime: %w", err)
+	}
+
+	return entries, flexitime, body, parseErr
+}
+
+// FetchMonthlyBalance returns the change of the flexi time balance in the current month until the previous day.
+func FetchMonthlyBalance(config MatrixConfig) (time.Duration, error) {
+	return FetchMonthlyBalanceContext(context.Background(), config, FetchOptions{})
+}
+
+// FetchMonthlyBalanceContext is like FetchMonthlyBalance but aborts all pending requests when ctx is cancelled.
+func FetchMonthlyBalanceContext(ctx context.Context, config MatrixConfig, options FetchOptions) (time.Duration, error) {
+	client, err := NewMatrixClientContext(ctx, config, options)
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to retrieve entries: %s", err.Error())
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		return 0, err
+	}
+	defer client.Close()
+
+	return client.GetMonthlyBalance()
+}
+
+// FetchFlexiTimeBalance returns the cumulative flexi time balance until the previous day, i.e. the balance carried over from previous months plus the change in the current month.
+func FetchFlexiTimeBalance(config MatrixConfig) (time.Duration, error) {
+	return FetchFlexiTimeBalanceContext(context.Background(), config, FetchOptions{})
+}
+
+// FetchFlexiTimeBalanceContext is like FetchFlexiTimeBalance but aborts all pending requests when ctx is cancelled.
+func FetchFlexiTimeBalanceContext(ctx context.Context, config MatrixConfig, options FetchOptions) (time.Duration, error) {
+	client, err := NewMatrixClientContext(ctx, config, options)
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		return 0, err
+	}
+	defer client.Close()
+
+	return client.GetFlexiTime()
+}
+
+// Ping checks whether host is reachable and serves the Matrix login page. No credentials are sent.
+func Ping(host string) error {
+	return PingContext(context.Background(), host, FetchOptions{})
+}
+
+// PingContext is like Ping but uses the connection settings of options. The timeout defaults to 5 seconds.
+func PingContext(ctx context.Context, host string, options FetchOptions) error {
+	if options.Timeout <= 0 {
+		options.Timeout = pingTimeout
+	}
+	options = options.withDefaults()
+
+	httpClient, err := newHTTPClient(options)
+	if err != nil {
+		return err
+	}
+
+	request, err := newRequest(ctx, options, http.MethodGet, host+options.URLs.Login, nil)
+	if err != nil {
+		return err
+	}
+	response, err := httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return newStatusError(response, http.StatusOK)
+	}
+	body, err := readBody(response, options.MaxBodySize)
+	if err != nil {
+		return err
+	}
+	if !isLoginPage(body) {
+		return fmt.Errorf("%s does not serve a Matrix login page", host)
+	}
+	return nil
+}
+
+// FetchResult contains all data retrieved by FetchMatrixResult.
+type FetchResult struct {
+	Entries   []Entry
+	FlexiTime time.Duration
+	// ServerTime is the time of the last response according to the server. It is zero if unknown.
+	ServerTime time.Time
+	// ClockSkew is the offset of the server clock to the local clock. Add it to time.Now() for projections based on server time.
+	ClockSkew time.Duration
+	// SessionTimeout is the duration an idle session remains valid. See MatrixClient.SessionTimeout for details.
+	SessionTimeout time.Duration
+	// ParseStats describes how many rows of the booking table have been recognized.
+	ParseStats ParseStats
+}
+
+// FetchMatrixResult is like FetchMatrixEntriesContext but also returns the server time. Skipped booking rows are returned as RowErrors together with the result unless StrictParse is set.
+func FetchMatrixResult(ctx context.Context, config MatrixConfig, options FetchOptions) (FetchResult, error) {
+	client, err := NewMatrixClientContext(ctx, config, options)
+	if err != nil {
+		if ctx.Err() != nil {
+			return FetchResult{}, ctx.Err()
+		}
+		return FetchResult{}, err
+	}
+	defer client.Close()
+
+	entries, parseErr := client.GetEntries()
+	if entries == nil {
+		if ctx.Err() != nil {
+			return FetchResult{}, ctx.Err()
+		}
+		return FetchResult{}, fmt.Errorf("failed to retrieve entries: %w", parseErr)
 	}
 
 	flexitime, err := client.GetFlexiTime()
 	if err != nil {
-		return nil, 0, fmt.Errorf("could not retrieve flexitime: %s", err.Error())
+		if ctx.Err() != nil {
+			return FetchResult{}, ctx.Err()
+		}
+		return FetchResult{}, fmt.Errorf("could not retrieve flexitime: %w", err)
+	}
+
+	return FetchResult{
+		Entries:        entries,
+		FlexiTime:      flexitime,
+		ServerTime:     client.ServerTime(),
+		ClockSkew:      client.ClockSkew(),
+		SessionTimeout: client.SessionTimeout(),
+		ParseStats:     client.ParseStats(),
+	}, parseErr
+}
+
+// FetchMatrixEntriesFunc calls fn for every entry available in "Aktuelle Buchungen" in Matrix. Processing stops and the error is returned as soon as fn returns an error.
+func FetchMatrixEntriesFunc(ctx context.Context, config MatrixConfig, options FetchOptions, fn func(Entry) error) error {
+	client, err := NewMatrixClientContext(ctx, config, options)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
 	}
+	defer client.Close()
 
-	return entries, flexitime, nil
+	return client.GetEntriesFunc(fn)
 }
 
 // MatrixConfig contains config parameters for Matrix connection and login.
@@ -50,9 +252,106 @@ type MatrixConfig struct {
 	Pass string `json:"pass" jcrypt:"aes"`
 }
 
-// DormaClient represents an authorized connection to Matrix.
+// FetchOptions contains optional parameters for the connection to Matrix.
+type FetchOptions struct {
+	// Timeout limits the duration of every single request including reading the response. Defaults to 30 seconds if zero.
+	Timeout time.Duration
+	// SessionCookieName is the name of the cookie that holds the session ID. Defaults to "JSESSIONID" if empty.
+	SessionCookieName string
+	// Retry defines whether login and retrieval of entries are repeated on server or network errors. Retries are disabled by default.
+	Retry RetryPolicy
+	// ProxyURL is the URL of an HTTP or SOCKS5 proxy like "socks5://localhost:1080". The proxy environment variables (HTTP_PROXY, HTTPS_PROXY, NO_PROXY) are used if empty.
+	ProxyURL string
+	// CACertFile is the path of a PEM file with certificates that are trusted in addition to the system pool, e.g. for self-signed hosts.
+	CACertFile string
+	// TLSServerName is the name used to verify the server certificate instead of the host name, e.g. when connecting by IP address.
+	TLSServerName string
+	// InsecureSkipVerify disables verification of the server certificate. Only use this for testing.
+	InsecureSkipVerify bool
+	// Logger receives debug messages about the communication with Matrix. Nothing is logged if nil.
+	Logger Logger
+	// HTTPClient is used for all requests instead of an internally constructed client, e.g. to use a custom transport. The client is copied and redirects are always handled by MatrixClient.
+	// ProxyURL, CACertFile, TLSServerName and InsecureSkipVerify configure the internal client and must not be set together with HTTPClient. Timeout is only applied if the client has no timeout of its own.
+	HTTPClient *http.Client
+	// StrictParse aborts retrieving entries at the first booking row that cannot be parsed. Otherwise invalid rows are skipped and returned as RowErrors together with all other entries.
+	StrictParse bool
+	// CheckOrder verifies that the bookings are listed in chronological order and returns ErrEntriesOutOfOrder otherwise. This detects layout changes that reverse the row order.
+	CheckOrder bool
+	// EntryTypeKeywords maps booking type labels of localized Matrix installations to entry types, e.g. {"in": EntryTypeCome, "out": EntryTypeLeave}. Labels are matched case-insensitive if they contain a keyword and the longest matching keyword wins.
+	// An empty entry type ignores the booking. Labels without matching keyword are parsed using the German and English labels of Matrix like "Kommen" and "Gehen".
+	EntryTypeKeywords map[string]EntryType
+	// MaxBodySize limits the size of a single page to protect against misconfigured hosts. Larger pages return ErrBodyTooLarge. Defaults to 8 MiB if zero.
+	MaxBodySize int64
+	// PrepareRequest is called for every request before it is sent, e.g. to add headers required by a web application firewall. The User-Agent header is already set and can be overridden.
+	PrepareRequest func(*http.Request)
+	// UserAgent is sent with every request. Defaults to "gohome/<Version>" if empty.
+	UserAgent string
+	// Location is the time zone of the Matrix user and used for the times of all entries and the current day. Defaults to time.Local if nil.
+	Location *time.Location
+	// SessionTimeout is the assumed duration an idle session remains valid if Matrix does not announce it. Defaults to 15 minutes if zero.
+	SessionTimeout time.Duration
+	// SessionCacheFile enables reusing a session across multiple clients. The session is stored in this file on Close instead of logging out and resumed by the next client if still valid.
+	SessionCacheFile string
+	// Trace receives a transcript of all requests and responses including the page contents, e.g. to attach it to a bug report. Passwords, cookies and session IDs are redacted. Nothing is written if nil.
+	Trace io.Writer
+	// URLs contains the paths of the Matrix pages. Other Matrix versions than 3.7.3 might use different paths.
+	URLs MatrixURLs
+}
+
+// MatrixURLs contains the paths of the Matrix pages relative to the host. Empty paths are replaced by the defaults for Matrix 3.7.3.
+type MatrixURLs struct {
+	// Login is the path of the login form like "/matrix-v3.7.3.75487/login.jspx".
+	Login string
+	// MainMenu is the path of the main menu used for navigation like "/matrix-v3.7.3.75487/mainMenu.jsf".
+	MainMenu string
+}
+
+// Logger is used to print debug messages. It is satisfied by *log.Logger.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Printf(string, ...interface{}) {}
+
+// withDefaults returns a copy of the options with default values for all unset fields.
+func (o FetchOptions) withDefaults() FetchOptions {
+	if o.Timeout <= 0 {
+		o.Timeout = defaultTimeout
+	}
+	if len(o.SessionCookieName) == 0 {
+		o.SessionCookieName = matrixSessionCookieName
+	}
+	if o.Logger == nil {
+		o.Logger = nopLogger{}
+	}
+	if len(o.UserAgent) == 0 {
+		o.UserAgent = "gohome/" + Version
+	}
+	if o.Location == nil {
+		o.Location = time.Local
+	}
+	if o.SessionTimeout <= 0 {
+		o.SessionTimeout = defaultSessionLifetime
+	}
+	if o.MaxBodySize <= 0 {
+		o.MaxBodySize = defaultMaxBodySize
+	}
+	if len(o.URLs.Login) == 0 {
+		o.URLs.Login = urlMatrixLogin
+	}
+	if len(o.URLs.MainMenu) == 0 {
+		o.URLs.MainMenu = urlMatrixMainMenu
+	}
+	return o
+}
+
+// MatrixClient represents an authorized session in Matrix. It can be used for multiple requests until Close is called.
 type MatrixClient struct {
+	ctx             context.Context
 	config          MatrixConfig
+	options         FetchOptions
 	httpClient      *http.Client
 	sessionID       string
 	rendermapToken  string
@@ -61,24 +360,53 @@ type MatrixClient struct {
 	lastVisitedPage string
 	nextUniqueToken string
 	nextViewState   string
+	closed          bool
+	serverTime      time.Time
+	clockSkew       time.Duration
+	sessionTimeout  time.Duration
+	parseStats      ParseStats
+	// lastActivity is the time of the last page received within the session.
+	lastActivity time.Time
 }
 
-// NewDormaClient returns a logged in DormaClient.
+// NewMatrixClient returns a logged in MatrixClient.
 func NewMatrixClient(config MatrixConfig) (*MatrixClient, error) {
+	return NewMatrixClientContext(context.Background(), config, FetchOptions{})
+}
+
+// NewMatrixClientContext returns a logged in MatrixClient that uses ctx for all requests during its lifetime.
+func NewMatrixClientContext(ctx context.Context, config MatrixConfig, options FetchOptions) (*MatrixClient, error) {
+	options = options.withDefaults()
+
+	httpClient, err := newHTTPClient(options)
+	if err != nil {
+		return nil, err
+	}
+
 	client := &MatrixClient{
-		config: config,
-		httpClient: &http.Client{
-			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{
-					InsecureSkipVerify: true,
-				},
-			},
-			CheckRedirect: func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse },
-		},
+		ctx:            ctx,
+		config:         config,
+		options:        options,
+		httpClient:     httpClient,
+		sessionTimeout: options.SessionTimeout,
+	}
+	client.setTimeZoneCookies()
+
+	if len(options.SessionCacheFile) > 0 {
+		if session, ok := loadCachedSession(options.SessionCacheFile, config.Host, config.User); ok {
+			client.restoreSession(session)
+			err := client.resumeSession()
+			if err == nil {
+				options.Logger.Printf("resumed cached session")
+				return client, nil
+			}
+			options.Logger.Printf("cached session is invalid: %s", err.Error())
+			client.restoreSession(cachedSession{})
+		}
 	}
 
-	if err := client.login(); err != nil {
-		return nil, fmt.Errorf("login failed: %s", err.Error())
+	if err := client.retry(client.login); err != nil {
+		return nil, fmt.Errorf("login failed: %w", err)
 	}
 	if err := client.visitSelfService(); err != nil {
 		return nil, fmt.Errorf("visit self-service failed: %s", err.Error())
@@ -86,8 +414,100 @@ func NewMatrixClient(config MatrixConfig) (*MatrixClient, error) {
 	return client, nil
 }
 
-// Close logs out from Matrix and closes the connection.
+// newHTTPClient returns the client used for all requests to Matrix. Redirects are not followed because the Matrix navigation relies on inspecting them.
+func newHTTPClient(options FetchOptions) (*http.Client, error) {
+	noRedirect := func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse }
+
+	if options.HTTPClient != nil {
+		if len(options.ProxyURL) > 0 || len(options.CACertFile) > 0 || len(options.TLSServerName) > 0 || options.InsecureSkipVerify {
+			return nil, fmt.Errorf("proxy and TLS options cannot be used together with a custom http client")
+		}
+
+		// copy the client to keep the redirect policy of the caller untouched
+		httpClient := *options.HTTPClient
+		httpClient.CheckRedirect = noRedirect
+		if httpClient.Jar == nil {
+			httpClient.Jar = newCookieJar()
+		}
+		if httpClient.Timeout <= 0 {
+			httpClient.Timeout = options.Timeout
+		}
+		if options.Trace != nil {
+			httpClient.Transport = newTraceTransport(httpClient.Transport, options.Trace)
+		}
+		return &httpClient, nil
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if len(options.ProxyURL) > 0 {
+		proxyURL, err := url.Parse(options.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy url: %s", err.Error())
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         options.TLSServerName,
+		InsecureSkipVerify: options.InsecureSkipVerify,
+	}
+	if len(options.CACertFile) > 0 {
+		rootCAs, err := loadCertPool(options.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	var transport http.RoundTripper = &http.Transport{
+		Proxy:           proxy,
+		TLSClientConfig: tlsConfig,
+	}
+	if options.Trace != nil {
+		transport = newTraceTransport(transport, options.Trace)
+	}
+
+	return &http.Client{
+		Transport:     transport,
+		CheckRedirect: noRedirect,
+		Jar:           newCookieJar(),
+		Timeout:       options.Timeout,
+	}, nil
+}
+
+func newCookieJar() http.CookieJar {
+	// cookiejar.New never fails without options
+	jar, _ := cookiejar.New(nil)
+	return jar
+}
+
+// loadCertPool returns the system cert pool extended by all certificates of a PEM file.
+func loadCertPool(file string) (*x509.CertPool, error) {
+	pemData, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificates: %s", err.Error())
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pemData) {
+		return nil, fmt.Errorf("no certificates found in %q", file)
+	}
+	return pool, nil
+}
+
+// Close logs out from Matrix and closes the connection. The session is kept alive and stored instead if SessionCacheFile is set. Calling Close more than once has no effect.
 func (c *MatrixClient) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+
+	if len(c.options.SessionCacheFile) > 0 {
+		return c.saveSession()
+	}
 	return c.logout()
 }
 
@@ -100,73 +520,141 @@ func (c *MatrixClient) login() error {
 	encodedTimeZoneOffset := url.QueryEscape(timeZoneOffset)
 	requestBody := fmt.Sprintf("userid=%s&password=%s&systemLevel=false&timezonename=%s&timezoneoffset=%s&timezonedst=true&loginButton=Anmeldung", encodedUser, encodedPass, encodedTimeZoneName, encodedTimeZoneOffset)
 
-	if _, err := c.postRedirect(urlMatrixLogin, requestBody); err != nil {
+	c.options.Logger.Printf("login to %s as %q", c.config.Host, c.config.User)
+	if _, err := c.postRedirect(c.options.URLs.Login, requestBody); err != nil {
+		var statusErr *statusError
+		if (errors.As(err, &statusErr) && statusErr.Code == http.StatusOK) || errors.Is(err, ErrSessionExpired) {
+			// Matrix renders the login form again instead of redirecting when the credentials are wrong
+			return fmt.Errorf("%w: %s", ErrAuthFailed, err.Error())
+		}
 		return err
 	}
+	c.options.Logger.Printf("session acquired")
 	return nil
 }
 
 func (c *MatrixClient) visitSelfService() error {
-	requestBody := "uniqueToken=" + c.nextUniqueToken + "&autoScroll=&agmenuform_SUBMIT=1&javax.faces.ViewState=" + c.nextViewState + "&activateMenuItem=mss_root&menuIndex=4&agmenuform%3AassemblyGroupMenu=agmenuform%3AassemblyGroupMenu&data-matrix-treepath=mss_root&agmenuform%3AassemblyGroupMenu_menuid=4"
-
-	if _, err := c.postRedirect(urlMatrixMainMenu, requestBody); err != nil {
+	if _, err := c.postRedirect(c.options.URLs.MainMenu, c.selfServiceRequestBody()); err != nil {
 		return nil
 	}
 	return nil
 }
 
+// resumeSession navigates to the self-service menu to check whether a restored session is still valid.
+func (c *MatrixClient) resumeSession() error {
+	_, err := c.postRedirect(c.options.URLs.MainMenu, c.selfServiceRequestBody())
+	return err
+}
+
+func (c *MatrixClient) selfServiceRequestBody() string {
+	return "uniqueToken=" + c.nextUniqueToken + "&autoScroll=&agmenuform_SUBMIT=1&javax.faces.ViewState=" + c.nextViewState + "&activateMenuItem=mss_root&menuIndex=4&agmenuform%3AassemblyGroupMenu=agmenuform%3AassemblyGroupMenu&data-matrix-treepath=mss_root&agmenuform%3AassemblyGroupMenu_menuid=4"
+}
+
 func (c *MatrixClient) logout() error {
+	c.options.Logger.Printf("logout")
 	return nil
 }
 
-// GetEntries returns all entries for the current day.
+// GetEntries returns all entries for the current day. Entries are also returned together with RowErrors for skipped rows unless StrictParse is set.
 func (c *MatrixClient) GetEntries() ([]Entry, error) {
-	requestBody := "uniqueToken=" + c.nextUniqueToken + "&menuform_SUBMIT=1&autoScroll=&javax.faces.ViewState=" + c.nextViewState + "&activateMenuItem=tim_searchWebBookingMss&menuform%3AmainMenu_mss_root_menuid=" + c.bookingID + "&data-matrix-treepath=mss_root.tim_searchWebBookingMss&menuform%3AmainMenu_mss_root=menuform%3AmainMenu_mss_root"
+	entries, _, err := c.GetEntriesRaw()
+	return entries, err
+}
 
-	body, err := c.postRedirect(c.lastVisitedPage, requestBody)
+// GetEntriesFunc calls fn for every entry of the current day. Processing stops and the error is returned as soon as fn returns an error.
+func (c *MatrixClient) GetEntriesFunc(fn func(Entry) error) error {
+	body, err := c.getEntriesPage()
 	if err != nil {
-		return nil, err
+		return err
 	}
+	count := 0
+	err = parseEntriesFunc(strings.NewReader(body), c.parseOptions(), func(entry Entry) error {
+		count++
+		return fn(entry)
+	})
+	c.options.Logger.Printf("parsed %d entries from %d rows", count, c.parseStats.Rows)
+	return err
+}
 
-	pattern := regexp.MustCompile(`title="(Uhrzeit \(SZ\)|Time \(ST\))" class="dateTimeMinuteValue">\s*(\d+):(\d+)\s*</span></td><td role="gridcell" class="tableColumnCenter"><span id="mainbody:editWebBooking:logTable:\d+:logTypeOfBookingTable">([^<]+)</span>`)
+// GetEntriesRaw returns all entries for the current day and the raw HTML they have been parsed from. Entries are also returned together with RowErrors for skipped rows unless StrictParse is set.
+func (c *MatrixClient) GetEntriesRaw() ([]Entry, string, error) {
+	body, err := c.getEntriesPage()
+	if err != nil {
+		return nil, body, err
+	}
+
+	entries, err := parseEntriesWith(strings.NewReader(body), c.parseOptions())
+	if entries == nil {
+		return nil, body, err
+	}
+	c.options.Logger.Printf("parsed %d entries from %d rows", len(entries), c.parseStats.Rows)
+	return entries, body, err
+}
 
-	today := time.Now()
+func (c *MatrixClient) parseOptions() parseOptions {
+	return parseOptions{Location: c.options.Location, Strict: c.options.StrictParse, Keywords: c.options.EntryTypeKeywords, CheckOrder: c.options.CheckOrder, Stats: &c.parseStats}
+}
 
-	matches := pattern.FindAllStringSubmatch(body, -1)
-	entries := make([]Entry, 0)
-	for _, m := range matches {
-		if len(m) != 5 {
-			continue
-		}
+// ParseStats returns how many rows of the booking table have been recognized by the last call to GetEntries, GetEntriesRaw or GetEntriesFunc.
+func (c *MatrixClient) ParseStats() ParseStats {
+	return c.parseStats
+}
 
-		hour, _ := strconv.Atoi(m[2])
-		minute, _ := strconv.Atoi(m[3])
-		date := time.Date(today.Year(), today.Month(), today.Day(), hour, minute, 0, 0, time.Local)
-
-		typeStr := m[4]
-		var entryType EntryType
-		if strings.Contains(strings.ToLower(typeStr), "kommen") || strings.Contains(strings.ToLower(typeStr), "arrive") {
-			entryType = EntryTypeCome
-		} else if strings.Contains(strings.ToLower(typeStr), "gehen") || strings.Contains(strings.ToLower(typeStr), "leave") || strings.Contains(strings.ToLower(typeStr), "hourly absence - end") {
-			entryType = EntryTypeLeave
-		} else if strings.Contains(strings.ToLower(typeStr), "???bookingtype.1034.name???") {
-			// "???BookingType.1034.name???" wird geschrieben, wenn man am Terminal den Kontostand abfragt
-			continue
-		} else {
-			return nil, fmt.Errorf("cannot parse entry type from %q", typeStr)
-		}
+// getEntriesPage navigates to "Aktuelle Buchungen" and returns the page content.
+func (c *MatrixClient) getEntriesPage() (string, error) {
+	requestBody := "uniqueToken=" + c.nextUniqueToken + "&menuform_SUBMIT=1&autoScroll=&javax.faces.ViewState=" + c.nextViewState + "&activateMenuItem=tim_searchWebBookingMss&menuform%3AmainMenu_mss_root_menuid=" + c.bookingID + "&data-matrix-treepath=mss_root.tim_searchWebBookingMss&menuform%3AmainMenu_mss_root=menuform%3AmainMenu_mss_root"
 
-		entries = append(entries, Entry{Time: date, Type: entryType})
+	// postRedirect updates lastVisitedPage, so remember the page for retries
+	page := c.lastVisitedPage
+	var body string
+	err := c.retry(func() error {
+		var err error
+		body, err = c.postRedirect(page, requestBody)
+		return err
+	})
+	if err != nil {
+		return body, fmt.Errorf("failed to load booking page (%s): %w", c.sessionState(), err)
 	}
+	return body, nil
+}
 
-	return entries, nil
+// sessionState describes the session for error messages without revealing the session ID.
+func (c *MatrixClient) sessionState() string {
+	if c.closed {
+		return "client closed"
+	}
+	if len(c.sessionID) == 0 {
+		return "no session"
+	}
+	if c.lastActivity.IsZero() {
+		return "session not used yet"
+	}
+	return fmt.Sprintf("session idle for %s of %s", time.Since(c.lastActivity).Round(time.Second), c.sessionTimeout)
 }
 
-// GetFlexiTime returns the current flexi time balance.
-func (c *MatrixClient) GetFlexiTime() (time.Duration, error) {
-	requestBody := "uniqueToken=" + c.nextUniqueToken + "&menuform_SUBMIT=1&autoScroll=&javax.faces.ViewState=" + c.nextViewState + "&activateMenuItem=tim_persMonthlyReconciliation&menuform%3AmainMenu_mss_root_menuid=" + c.monthDataID + "&data-matrix-treepath=mss_root.tim_persMonthlyReconciliation&menuform%3AmainMenu_mss_root=menuform%3AmainMenu_mss_root"
+// newStatusError returns a statusError for an unexpected response including the URL and the beginning of the body. Session IDs are removed from both. The body is not closed.
+func newStatusError(response *http.Response, expected int) *statusError {
+	err := &statusError{Code: response.StatusCode, Expected: expected}
+	if response.Request != nil && response.Request.URL != nil {
+		err.URL = redactURL(response.Request.URL.String())
+	}
+	data, _ := io.ReadAll(io.LimitReader(response.Body, statusSnippetReadSize))
+	err.Snippet = bodySnippet(string(data))
+	return err
+}
 
-	body, err := c.postRedirect(c.lastVisitedPage, requestBody)
+// bodySnippet returns the beginning of a response body with collapsed white space for error messages.
+func bodySnippet(body string) string {
+	snippet := []rune(strings.Join(strings.Fields(body), " "))
+	if len(snippet) > statusSnippetLength {
+		return redactURL(string(snippet[:statusSnippetLength])) + "..."
+	}
+	return redactURL(string(snippet))
+}
+
+// GetFlexiTime returns the cumulative flexi time balance until the previous day.
+func (c *MatrixClient) GetFlexiTime() (time.Duration, error) {
+	body, err := c.getMonthlyReconciliationPage()
 	if err != nil {
 		return 0, err
 	}
@@ -174,31 +662,124 @@ func (c *MatrixClient) GetFlexiTime() (time.Duration, error) {
 	return c.parseFlexiTime(body)
 }
 
+// getMonthlyReconciliationPage navigates to the monthly reconciliation and returns the page content.
+func (c *MatrixClient) getMonthlyReconciliationPage() (string, error) {
+	requestBody := "uniqueToken=" + c.nextUniqueToken + "&menuform_SUBMIT=1&autoScroll=&javax.faces.ViewState=" + c.nextViewState + "&activateMenuItem=tim_persMonthlyReconciliation&menuform%3AmainMenu_mss_root_menuid=" + c.monthDataID + "&data-matrix-treepath=mss_root.tim_persMonthlyReconciliation&menuform%3AmainMenu_mss_root=menuform%3AmainMenu_mss_root"
+	return c.postRedirect(c.lastVisitedPage, requestBody)
+}
+
 func (c *MatrixClient) parseFlexiTime(body string) (time.Duration, error) {
-	pattern := regexp.MustCompile(`<td class="tableColumnRight" title="(Saldo Vortag|Balance previous day)" width="100"><span id="mainbody:editPersRecord:monthrecon:listDynTable:\d+:contentj_id__v_4">\s*(-?)\s*[&nbsp;]*\s*(\d+):(\d+)\s*</span>`)
+	balances, err := parseDailyBalances(body)
+	if err != nil {
+		return 0, err
+	}
+	return balances[len(balances)-1], nil
+}
+
+// GetMonthlyBalance returns the change of the flexi time balance in the current month until the previous day.
+func (c *MatrixClient) GetMonthlyBalance() (time.Duration, error) {
+	body, err := c.getMonthlyReconciliationPage()
+	if err != nil {
+		return 0, err
+	}
+
+	balances, err := parseDailyBalances(body)
+	if err != nil {
+		return 0, err
+	}
+	// the first row contains the balance carried over from the previous month
+	return balances[len(balances)-1] - balances[0], nil
+}
+
+// parseDailyBalances returns the "Saldo Vortag" column of the monthly reconciliation in order of appearance. Empty cells of days that have not been reconciled yet are skipped.
+func parseDailyBalances(body string) ([]time.Duration, error) {
+	pattern := regexp.MustCompile(`<td class="tableColumnRight" title="(Saldo Vortag|Balance previous day)" width="100"><span id="mainbody:editPersRecord:monthrecon:listDynTable:\d+:contentj_id__v_4">([^<]*)</span>`)
 	matches := pattern.FindAllStringSubmatch(body, -1)
 	if len(matches) == 0 {
-		return 0, fmt.Errorf("unable to parse current flexi-time balance")
+		return nil, fmt.Errorf("unable to parse current flexi-time balance")
 	}
 
-	m := matches[len(matches)-1]
+	balances := make([]time.Duration, 0, len(matches))
+	for _, m := range matches {
+		if len(strings.TrimSpace(strings.Replace(m[2], "&nbsp;", "", -1))) == 0 {
+			continue
+		}
+		balance, err := parseBalance(m[2])
+		if err != nil {
+			return nil, err
+		}
+		balances = append(balances, balance)
+	}
+	if len(balances) == 0 {
+		return nil, fmt.Errorf("unable to parse current flexi-time balance")
+	}
+	return balances, nil
+}
+
+// parseBalance parses a balance in hours like "-3:15", "- 3:15", "+3:15" or in decimal hours like "-3,25".
+func parseBalance(str string) (time.Duration, error) {
+	str = strings.Replace(str, "&nbsp;", "", -1)
+	str = strings.Join(strings.Fields(str), "")
 
-	sign := 1
-	if m[2] == "-" {
+	sign := time.Duration(1)
+	if strings.HasPrefix(str, "-") {
 		sign = -1
+		str = str[1:]
+	} else if strings.HasPrefix(str, "+") {
+		str = str[1:]
+	}
+
+	if m := regexp.MustCompile(`^(\d+):(\d{2})$`).FindStringSubmatch(str); m != nil {
+		hours, _ := strconv.Atoi(m[1])
+		minutes, _ := strconv.Atoi(m[2])
+		return sign * (time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute), nil
 	}
-	hours, _ := strconv.Atoi(m[3])
-	minutes, _ := strconv.Atoi(m[4])
-	return time.Duration(sign) * (time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute), nil
+	if regexp.MustCompile(`^\d+([.,]\d+)?$`).MatchString(str) {
+		hours, err := strconv.ParseFloat(strings.Replace(str, ",", ".", 1), 64)
+		if err != nil {
+			return 0, err
+		}
+		return sign * time.Duration(hours*float64(time.Hour)).Round(time.Minute), nil
+	}
+	return 0, fmt.Errorf("cannot parse balance from %q", str)
+}
+
+// readBody returns the response body decoded to UTF-8 and fails with ErrBodyTooLarge if the body exceeds maxSize. The character encoding is taken from the Content-Type header or a meta tag, because Matrix pages are often served as ISO-8859-1.
+func readBody(response *http.Response, maxSize int64) (string, error) {
+	// read one more byte to distinguish a page of exactly maxSize from a larger one
+	data, err := io.ReadAll(io.LimitReader(response.Body, maxSize+1))
+	if err != nil {
+		return "", err
+	}
+	if int64(len(data)) > maxSize {
+		return "", fmt.Errorf("%w: more than %d bytes", ErrBodyTooLarge, maxSize)
+	}
+	return decodeBody(data, response.Header.Get("Content-Type"))
+}
+
+// decodeBody converts an HTML page to UTF-8 using the encoding from contentType, a byte order mark or a meta tag. Valid UTF-8 without any declaration is returned unchanged.
+func decodeBody(data []byte, contentType string) (string, error) {
+	encoding, name, _ := charset.DetermineEncoding(data, contentType)
+	if name == "utf-8" {
+		return string(data), nil
+	}
+	decoded, err := encoding.NewDecoder().Bytes(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode %s body: %w", name, err)
+	}
+	return string(decoded), nil
 }
 
 func (c *MatrixClient) postRedirect(url, body string) (string, error) {
+	if c.closed {
+		return "", ErrClientClosed
+	}
+
 	firstURL := c.config.Host + url
-	request, err := http.NewRequest(http.MethodPost, firstURL, strings.NewReader(body))
+	request, err := newRequest(c.ctx, c.options, http.MethodPost, firstURL, strings.NewReader(body))
 	if err != nil {
 		return "", err
 	}
-	c.setCookies(request)
 	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
 	response, err := c.httpClient.Do(request)
@@ -206,22 +787,35 @@ func (c *MatrixClient) postRedirect(url, body string) (string, error) {
 		return "", err
 	}
 	if response.StatusCode != 302 {
-		return "", fmt.Errorf("server returned code %d when 302 was expected", response.StatusCode)
+		defer response.Body.Close()
+		return "", newStatusError(response, 302)
 	}
+	// the content of the redirect is not needed
+	response.Body.Close()
 
 	c.evalCookies(response)
+	c.evalDate(response)
+
+	// check the target first, a login redirected to an SSO page would otherwise only report the missing cookie
+	location, err := c.redirectPath(response.Header.Get("Location"))
+	if err != nil {
+		return "", err
+	}
 
 	if len(c.sessionID) == 0 {
-		return "", fmt.Errorf("missing Cookie " + matrixSessionCookieName)
+		return "", fmt.Errorf("missing Cookie " + c.options.SessionCookieName)
+	}
+
+	if isLoginLocation(location, c.options.URLs.Login) {
+		return "", fmt.Errorf("%w: %s redirected to the login page", ErrSessionExpired, redactURL(url))
 	}
 
-	request, err = http.NewRequest(http.MethodGet, c.config.Host+response.Header.Get("Location"), nil)
+	request, err = newRequest(c.ctx, c.options, http.MethodGet, c.config.Host+location, nil)
 	if err != nil {
 		return "", err
 	}
-	c.setCookies(request)
 
-	c.lastVisitedPage = response.Header.Get("Location")
+	c.lastVisitedPage = location
 	if matrixDebugPrint {
 		fmt.Println("lastVisitedPage:", c.lastVisitedPage)
 	}
@@ -230,17 +824,26 @@ func (c *MatrixClient) postRedirect(url, body string) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	defer response.Body.Close()
 	if response.StatusCode != 200 {
-		return "", fmt.Errorf("server returned code %d when 200 was expected", response.StatusCode)
+		return "", newStatusError(response, 200)
 	}
 
-	buffer, err := io.ReadAll(response.Body)
+	body, err = readBody(response, c.options.MaxBodySize)
 	if err != nil {
 		return "", err
 	}
-	body = string(buffer)
+	c.options.Logger.Printf("received %d bytes from %s", len(body), request.URL.Path)
 
 	c.evalCookies(response)
+	c.evalDate(response)
+	c.evalSessionTimeout(body)
+
+	if isLoginPage(body) {
+		// an expired session shows the login form with status 200, so it must not be mistaken for an empty page
+		return body, fmt.Errorf("%w: %s shows the login page", ErrSessionExpired, redactURL(location))
+	}
+	c.lastActivity = time.Now()
 
 	pattern := regexp.MustCompile(`<input type="hidden" name="uniqueToken" value="([^"]*)" />`)
 	m := pattern.FindStringSubmatch(body)
@@ -283,21 +886,99 @@ func (c *MatrixClient) postRedirect(url, body string) (string, error) {
 	return body, nil
 }
 
-func (c *MatrixClient) setCookies(request *http.Request) {
-	if len(c.sessionID) > 0 {
-		request.AddCookie(&http.Cookie{Name: matrixSessionCookieName, Value: c.sessionID})
+// newRequest returns a request with all headers that are common to requests to Matrix.
+func newRequest(ctx context.Context, options FetchOptions, method, url string, body io.Reader) (*http.Request, error) {
+	request, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("User-Agent", options.UserAgent)
+	if options.PrepareRequest != nil {
+		options.PrepareRequest(request)
+	}
+	return request, nil
+}
+
+// redirectPath returns the path of a redirect target relative to the Matrix host. Redirects to other hosts, e.g. an SSO page of a reverse proxy, are not followed and return ErrRedirectedOffHost.
+func (c *MatrixClient) redirectPath(location string) (string, error) {
+	if len(location) == 0 {
+		return "", fmt.Errorf("redirect without location")
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return "", fmt.Errorf("invalid redirect location %q: %w", location, err)
+	}
+	if len(u.Host) == 0 {
+		return location, nil
+	}
+
+	host, err := url.Parse(c.config.Host)
+	if err != nil {
+		return "", err
+	}
+	if !strings.EqualFold(u.Hostname(), host.Hostname()) {
+		return "", fmt.Errorf("%w: %s://%s%s", ErrRedirectedOffHost, u.Scheme, u.Host, u.Path)
+	}
+	return u.RequestURI(), nil
+}
+
+// isLoginLocation returns true if a redirect target points to the login page. Only the file name of loginURL is compared, because Matrix might redirect to the login page of another version.
+func isLoginLocation(location, loginURL string) bool {
+	u, err := url.Parse(location)
+	if err != nil {
+		return false
+	}
+	login, err := url.Parse(loginURL)
+	if err != nil {
+		return false
+	}
+	return strings.HasSuffix(u.Path, "/"+path.Base(login.Path))
+}
+
+// isLoginPage returns true if body contains the login form of Matrix.
+func isLoginPage(body string) bool {
+	return strings.Contains(body, `name="loginButton"`) && strings.Contains(body, `name="password"`)
+}
+
+// setJarCookies stores cookies for the Matrix host in the cookie jar. Cookies with empty value are removed.
+func (c *MatrixClient) setJarCookies(cookies []*http.Cookie) {
+	hostURL, err := url.Parse(c.config.Host)
+	if c.httpClient == nil || c.httpClient.Jar == nil || err != nil {
+		return
 	}
-	if len(c.rendermapToken) > 0 {
-		request.AddCookie(&http.Cookie{Name: matrixRendermapTokenCookieName, Value: c.rendermapToken})
+	for _, cookie := range cookies {
+		if len(cookie.Value) == 0 {
+			cookie.MaxAge = -1
+		}
+		if len(cookie.Path) == 0 {
+			cookie.Path = "/"
+		}
 	}
-	request.AddCookie(&http.Cookie{Name: "timezonedst", Value: "true"})
-	request.AddCookie(&http.Cookie{Name: "timezonename", Value: "Europe/Berlin"})
-	request.AddCookie(&http.Cookie{Name: "timezoneoffset", Value: "+01:00"})
+	c.httpClient.Jar.SetCookies(hostURL, cookies)
+}
+
+// setTimeZoneCookies stores the time zone cookies that are sent with every request after login.
+func (c *MatrixClient) setTimeZoneCookies() {
+	c.setJarCookies([]*http.Cookie{
+		{Name: "timezonedst", Value: "true"},
+		{Name: "timezonename", Value: "Europe/Berlin"},
+		{Name: "timezoneoffset", Value: "+01:00"},
+	})
 }
 
+// jarCookies returns all cookies of the cookie jar that are sent to the Matrix host.
+func (c *MatrixClient) jarCookies() []*http.Cookie {
+	hostURL, err := url.Parse(c.config.Host)
+	if c.httpClient == nil || c.httpClient.Jar == nil || err != nil {
+		return nil
+	}
+	return c.httpClient.Jar.Cookies(hostURL)
+}
+
+// evalCookies remembers session cookies of a response. All cookies are sent with following requests by the cookie jar.
 func (c *MatrixClient) evalCookies(response *http.Response) {
 	for _, cookie := range response.Cookies() {
-		if cookie.Name == matrixSessionCookieName {
+		if cookie.Name == c.options.SessionCookieName {
 			if matrixDebugPrint {
 				fmt.Println("SessionID:", cookie.Value)
 			}
@@ -311,3 +992,41 @@ func (c *MatrixClient) evalCookies(response *http.Response) {
 		}
 	}
 }
+
+// evalDate remembers the server time from the Date header of a response.
+func (c *MatrixClient) evalDate(response *http.Response) {
+	date, err := http.ParseTime(response.Header.Get("Date"))
+	if err != nil {
+		return
+	}
+	c.serverTime = date
+	c.clockSkew = date.Sub(time.Now().Truncate(time.Second))
+}
+
+// evalSessionTimeout remembers the session timeout from a meta refresh to the login page, which Matrix uses to show the login form after the session expired.
+func (c *MatrixClient) evalSessionTimeout(body string) {
+	m := patternMetaRefresh.FindStringSubmatch(body)
+	if len(m) != 3 || !isLoginLocation(html.UnescapeString(m[2]), c.options.URLs.Login) {
+		return
+	}
+	seconds, err := strconv.Atoi(m[1])
+	if err != nil || seconds <= 0 {
+		return
+	}
+	c.sessionTimeout = time.Duration(seconds) * time.Second
+}
+
+// SessionTimeout returns the duration an idle session remains valid as announced by the last page. FetchOptions.SessionTimeout is returned if Matrix did not announce a timeout.
+func (c *MatrixClient) SessionTimeout() time.Duration {
+	return c.sessionTimeout
+}
+
+// ServerTime returns the time of the last response according to the Date header. It is zero if the server did not send a Date header.
+func (c *MatrixClient) ServerTime() time.Time {
+	return c.serverTime
+}
+
+// ClockSkew returns the offset of the server clock to the local clock at the time of the last response. The accuracy is limited to one second by the Date header.
+func (c *MatrixClient) ClockSkew() time.Duration {
+	return c.clockSkew
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsLoginPage(t *testing.T) {
+	assert.True(t, isLoginPage(readFixture(t, "login.html")))
+	assert.False(t, isLoginPage(readFixture(t, "entries_default.html")))
+}
+
+func TestIsLoginLocation(t *testing.T) {
+	assert.True(t, isLoginLocation("/matrix-v3.7.3.75487/login.jspx", urlMatrixLogin))
+	assert.True(t, isLoginLocation("/matrix-v3.7.3.75487/login.jspx?expired=true", urlMatrixLogin))
+	assert.True(t, isLoginLocation("/matrix-v3.8.0.1/login.jspx", urlMatrixLogin))
+	assert.False(t, isLoginLocation("/matrix-v3.7.3.75487/mainMenu.jsf", urlMatrixLogin))
+	assert.True(t, isLoginLocation("/matrix/signin.jsf", "/matrix/signin.jsf"))
+}
+
+func TestNewHTTPClientCustom(t *testing.T) {
+	custom := &http.Client{Timeout: 5 * time.Second}
+	client, err := newHTTPClient(FetchOptions{HTTPClient: custom}.withDefaults())
+	require.NoError(t, err)
+	assert.NotSame(t, custom, client)
+	assert.Nil(t, custom.CheckRedirect)
+	assert.NotNil(t, client.CheckRedirect)
+	assert.Equal(t, 5*time.Second, client.Timeout)
+
+	client, err = newHTTPClient(FetchOptions{HTTPClient: &http.Client{}, Timeout: 3 * time.Second}.withDefaults())
+	require.NoError(t, err)
+	assert.Equal(t, 3*time.Second, client.Timeout)
+
+	_, err = newHTTPClient(FetchOptions{HTTPClient: custom, ProxyURL: "http://proxy:3128"}.withDefaults())
+	assert.Error(t, err)
+}
+
+func TestMatrixClientCloseIdempotent(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "session.json")
+	client := &MatrixClient{
+		config:         MatrixConfig{Host: "https://matrix.example.com", User: "jdoe"},
+		options:        FetchOptions{SessionCacheFile: file}.withDefaults(),
+		sessionID:      "0123456789ABCDEF",
+		sessionTimeout: time.Minute,
+		httpClient:     &http.Client{Jar: newCookieJar()},
+	}
+	client.setJarCookies([]*http.Cookie{{Name: matrixSessionCookieName, Value: "0123456789ABCDEF"}})
+
+	require.NoError(t, client.Close())
+	session, ok := loadCachedSession(file, "https://matrix.example.com", "jdoe")
+	require.True(t, ok)
+	assert.Equal(t, "0123456789ABCDEF", session.SessionID)
+	require.NoError(t, os.Remove(file))
+
+	require.NoError(t, client.Close())
+	assert.NoFileExists(t, file)
+
+	_, err := client.GetEntries()
+	assert.True(t, errors.Is(err, ErrClientClosed))
+}
+
+func TestParseDailyBalances(t *testing.T) {
+	balances, err := parseDailyBalances(readFixture(t, "monthrecon.html"))
+	require.NoError(t, err)
+	assert.Equal(t, []time.Duration{2*time.Hour + 10*time.Minute, time.Hour + 40*time.Minute, -45 * time.Minute}, balances)
+
+	_, err = parseDailyBalances(readFixture(t, "entries_default.html"))
+	assert.Error(t, err)
+
+	// a month without any reconciled day has no balance
+	_, err = parseDailyBalances(`<td class="tableColumnRight" title="Saldo Vortag" width="100"><span id="mainbody:editPersRecord:monthrecon:listDynTable:0:contentj_id__v_4">&nbsp;</span>`)
+	assert.Error(t, err)
+}
+
+type balanceCase struct {
+	Input       string
+	Balance     time.Duration
+	ExpectError bool
+}
+
+func TestParseBalance(t *testing.T) {
+	testCases := []balanceCase{
+		{Input: "3:15", Balance: 3*time.Hour + 15*time.Minute},
+		{Input: " -3:15 ", Balance: -3*time.Hour - 15*time.Minute},
+		{Input: "-&nbsp;0:05", Balance: -5 * time.Minute},
+		{Input: "- 12:00", Balance: -12 * time.Hour},
+		{Input: "+1:30", Balance: 90 * time.Minute},
+		{Input: "\u00a0-0:45\u00a0", Balance: -45 * time.Minute},
+		{Input: "+-1:30", ExpectError: true},
+		{Input: "3,25", Balance: 3*time.Hour + 15*time.Minute},
+		{Input: "-1.5", Balance: -90 * time.Minute},
+		{Input: "7", Balance: 7 * time.Hour},
+		{Input: "3:5", ExpectError: true},
+		{Input: "abc", ExpectError: true},
+		{Input: "", ExpectError: true},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.Input, func(t *testing.T) {
+			balance, err := parseBalance(c.Input)
+			if c.ExpectError {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, c.Balance, balance)
+			}
+		})
+	}
+}
+
+func TestEvalDate(t *testing.T) {
+	client := &MatrixClient{}
+	client.evalDate(&http.Response{Header: http.Header{}})
+	assert.True(t, client.ServerTime().IsZero())
+	assert.Equal(t, time.Duration(0), client.ClockSkew())
+
+	serverTime := time.Now().Add(-90 * time.Second).UTC().Truncate(time.Second)
+	client.evalDate(&http.Response{Header: http.Header{"Date": []string{serverTime.Format(http.TimeFormat)}}})
+	assert.True(t, serverTime.Equal(client.ServerTime()))
+	assertBetween(t, -92*time.Second, -88*time.Second, client.ClockSkew())
+}
+
+func TestDecodeBody(t *testing.T) {
+	latin1 := []byte(readFixture(t, "entries_latin1.html"))
+
+	// the encoding is declared by a meta tag
+	body, err := decodeBody(latin1, "text/html")
+	require.NoError(t, err)
+	assert.Contains(t, body, "Kommen (Außendienst)")
+	assert.Contains(t, body, "Gehen (Büro)")
+
+	entries, err := parseEntriesFromHTML(body, time.Local)
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{
+		{Type: EntryTypeCome, Time: today(7, 30)},
+		{Type: EntryTypePauseStart, Time: today(11, 45)},
+		{Type: EntryTypePauseEnd, Time: today(12, 15)},
+		{Type: EntryTypeLeave, Time: today(16, 0)},
+	}, entries)
+
+	// the Content-Type header takes precedence over a missing or wrong declaration in the page
+	body, err = decodeBody([]byte("<p>Gr\xfc\xdfe</p>"), "text/html; charset=windows-1252")
+	require.NoError(t, err)
+	assert.Equal(t, "<p>Grüße</p>", body)
+
+	body, err = decodeBody([]byte("<p>Grüße</p>"), "text/html")
+	require.NoError(t, err)
+	assert.Equal(t, "<p>Grüße</p>", body)
+}
+
+func TestFetchMatrixEntriesMock(t *testing.T) {
+	mock := newMockMatrix(t)
+
+	entries, flexiTime, err := FetchMatrixEntriesContext(context.Background(), mock.Config(), FetchOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{
+		{Type: EntryTypeCome, Time: today(7, 58)},
+		{Type: EntryTypeLeave, Time: today(12, 3)},
+		{Type: EntryTypeCome, Time: today(12, 44)},
+	}, entries)
+	assert.Equal(t, -45*time.Minute, flexiTime)
+
+	balance, err := FetchMonthlyBalanceContext(context.Background(), mock.Config(), FetchOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, -2*time.Hour-55*time.Minute, balance)
+
+	balance, err = FetchFlexiTimeBalanceContext(context.Background(), mock.Config(), FetchOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, -45*time.Minute, balance)
+}
+
+func TestFetchMatrixEntriesMockWrongPassword(t *testing.T) {
+	mock := newMockMatrix(t)
+	config := mock.Config()
+	config.Pass = "wrong"
+
+	_, _, err := FetchMatrixEntriesContext(context.Background(), config, FetchOptions{})
+	assert.True(t, errors.Is(err, ErrAuthFailed))
+}
+
+func TestMatrixClientMockSessionExpired(t *testing.T) {
+	mock := newMockMatrix(t)
+	client, err := NewMatrixClientContext(context.Background(), mock.Config(), FetchOptions{})
+	require.NoError(t, err)
+	defer client.Close()
+
+	mock.ExpireSessions()
+	_, err = client.GetEntries()
+	assert.True(t, errors.Is(err, ErrSessionExpired))
+	assert.Contains(t, err.Error(), "redirected to the login page")
+	assert.Contains(t, err.Error(), "session idle for")
+	assert.NotContains(t, err.Error(), "SESSION")
+}
+
+func TestMatrixClientMockStatusErrorContext(t *testing.T) {
+	mock := newMockMatrix(t)
+	client, err := NewMatrixClientContext(context.Background(), mock.Config(), FetchOptions{})
+	require.NoError(t, err)
+	defer client.Close()
+
+	mock.FailNext(1, http.StatusServiceUnavailable)
+	_, err = client.GetEntries()
+	assert.True(t, errors.Is(err, ErrServerUnavailable))
+	assert.Contains(t, err.Error(), "server returned code 503 when 302 was expected for "+mock.URL+mockMatrixPrefix+"/")
+	assert.Contains(t, err.Error(), "failed to load booking page (session idle for")
+}
+
+func TestNewStatusError(t *testing.T) {
+	requestURL, err := url.Parse("https://matrix.example.com/mss/bookings.jsf;jsessionid=ABC123?x=1")
+	require.NoError(t, err)
+	body := "<html>\n  <head><title>Service Unavailable</title></head>\n  <body><a href=\"/mss/login.jspx;jsessionid=ABC123\">" + strings.Repeat("x", 200) + "</a></body></html>"
+	statusErr := newStatusError(&http.Response{StatusCode: 503, Request: &http.Request{URL: requestURL}, Body: io.NopCloser(strings.NewReader(body))}, 200)
+
+	assert.Equal(t, 503, statusErr.Code)
+	assert.NotContains(t, statusErr.Error(), "ABC123")
+	assert.Contains(t, statusErr.Error(), "for https://matrix.example.com/mss/bookings.jsf;jsessionid=")
+	assert.Contains(t, statusErr.Error(), `response starts with "<html> <head><title>Service Unavailable</title></head> <body>`)
+	assert.True(t, strings.HasSuffix(statusErr.Snippet, "..."))
+	assert.True(t, errors.Is(statusErr, ErrServerUnavailable))
+
+	assert.Equal(t, "server returned code 404 when 200 was expected", (&statusError{Code: 404, Expected: 200}).Error())
+}
+
+func TestMatrixClientMockRetry(t *testing.T) {
+	mock := newMockMatrix(t)
+	mock.FailNext(2, http.StatusServiceUnavailable)
+
+	_, _, err := FetchMatrixEntriesContext(context.Background(), mock.Config(), FetchOptions{
+		Retry: RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+	})
+	require.NoError(t, err)
+}
+
+func TestMatrixClientMockSessionCache(t *testing.T) {
+	mock := newMockMatrix(t)
+	options := FetchOptions{SessionCacheFile: filepath.Join(t.TempDir(), "session.json")}
+
+	_, _, err := FetchMatrixEntriesContext(context.Background(), mock.Config(), options)
+	require.NoError(t, err)
+	requests := mock.Requests()
+
+	// the cached session is resumed without login
+	_, _, err = FetchMatrixEntriesContext(context.Background(), mock.Config(), options)
+	require.NoError(t, err)
+	assert.Equal(t, 6, mock.Requests()-requests)
+}
+
+func TestNewHTTPClientTLSServerName(t *testing.T) {
+	client, err := newHTTPClient(FetchOptions{TLSServerName: "matrix.example.com"}.withDefaults())
+	require.NoError(t, err)
+	transport, ok := client.Transport.(*http.Transport)
+	require.True(t, ok)
+	assert.Equal(t, "matrix.example.com", transport.TLSClientConfig.ServerName)
+	assert.False(t, transport.TLSClientConfig.InsecureSkipVerify)
+
+	_, err = newHTTPClient(FetchOptions{HTTPClient: &http.Client{}, TLSServerName: "matrix.example.com"}.withDefaults())
+	assert.Error(t, err)
+}
+
+func TestPing(t *testing.T) {
+	mock := newMockMatrix(t)
+	assert.NoError(t, Ping(mock.URL))
+
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html><body>It works!</body></html>"))
+	}))
+	defer other.Close()
+	assert.Error(t, Ping(other.URL))
+
+	mock.FailNext(1, http.StatusBadGateway)
+	assert.True(t, errors.Is(Ping(mock.URL), ErrServerUnavailable))
+}
+
+func TestUserAgent(t *testing.T) {
+	mock := newMockMatrix(t)
+	require.NoError(t, Ping(mock.URL))
+	assert.Equal(t, []string{"gohome/" + Version}, mock.UserAgents())
+
+	mock = newMockMatrix(t)
+	_, _, err := FetchMatrixEntriesContext(context.Background(), mock.Config(), FetchOptions{UserAgent: "acme-timetracker/1.0"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"acme-timetracker/1.0"}, mock.UserAgents())
+}
+
+func TestFetchMatrixEntriesURLs(t *testing.T) {
+	mock := newMockMatrix(t)
+	mock.Prefix = "/matrix-v3.8.0.1"
+
+	_, _, err := FetchMatrixEntriesContext(context.Background(), mock.Config(), FetchOptions{})
+	require.Error(t, err)
+
+	urls := MatrixURLs{Login: mock.Prefix + "/login.jspx", MainMenu: mock.Prefix + "/mainMenu.jsf"}
+	require.NoError(t, PingContext(context.Background(), mock.URL, FetchOptions{URLs: urls}))
+	entries, _, err := FetchMatrixEntriesContext(context.Background(), mock.Config(), FetchOptions{URLs: urls})
+	require.NoError(t, err)
+	assert.Len(t, entries, 3)
+}
+
+type redirectPathCase struct {
+	Location    string
+	Path        string
+	ExpectError bool
+}
+
+func TestRedirectPath(t *testing.T) {
+	client := &MatrixClient{config: MatrixConfig{Host: "https://matrix.example.com"}}
+	testCases := []redirectPathCase{
+		{Location: "/matrix-v3.7.3.75487/mainMenu.jsf", Path: "/matrix-v3.7.3.75487/mainMenu.jsf"},
+		{Location: "https://matrix.example.com/matrix-v3.7.3.75487/mainMenu.jsf?a=1", Path: "/matrix-v3.7.3.75487/mainMenu.jsf?a=1"},
+		{Location: "http://MATRIX.example.com:8080/mainMenu.jsf", Path: "/mainMenu.jsf"},
+		{Location: "https://sso.example.com/auth?client=matrix", ExpectError: true},
+		{Location: "//sso.example.com/auth", ExpectError: true},
+		{Location: "", ExpectError: true},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.Location, func(t *testing.T) {
+			path, err := client.redirectPath(c.Location)
+			if c.ExpectError {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, c.Path, path)
+			}
+		})
+	}
+}
+
+func TestFetchMatrixEntriesRedirectedOffHost(t *testing.T) {
+	mock := newMockMatrix(t)
+	mock.LoginRedirect = "https://sso.example.com/auth?client=matrix"
+
+	_, _, err := FetchMatrixEntriesContext(context.Background(), mock.Config(), FetchOptions{})
+	assert.True(t, errors.Is(err, ErrRedirectedOffHost))
+	assert.Contains(t, err.Error(), "https://sso.example.com/auth")
+	assert.NotContains(t, err.Error(), "missing Cookie")
+}
+
+func TestPrepareRequest(t *testing.T) {
+	mock := newMockMatrix(t)
+
+	prepared := 0
+	options := FetchOptions{PrepareRequest: func(request *http.Request) {
+		prepared++
+		request.Header.Set("X-Corp-Token", "token")
+		request.Header.Set("User-Agent", "corp-proxy/1.0")
+	}}
+	_, _, err := FetchMatrixEntriesContext(context.Background(), mock.Config(), options)
+	require.NoError(t, err)
+	assert.Equal(t, mock.Requests(), prepared)
+	assert.Equal(t, []string{"corp-proxy/1.0"}, mock.UserAgents())
+}
+
+func TestReadBodyMaxSize(t *testing.T) {
+	newResponse := func(body string) *http.Response {
+		return &http.Response{Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body))}
+	}
+
+	body, err := readBody(newResponse("<p>1234</p>"), 11)
+	require.NoError(t, err)
+	assert.Equal(t, "<p>1234</p>", body)
+
+	_, err = readBody(newResponse("<p>12345</p>"), 11)
+	assert.True(t, errors.Is(err, ErrBodyTooLarge))
+}
+
+func TestFetchMatrixEntriesMaxBodySize(t *testing.T) {
+	mock := newMockMatrix(t)
+	_, _, err := FetchMatrixEntriesContext(context.Background(), mock.Config(), FetchOptions{MaxBodySize: 512})
+	assert.True(t, errors.Is(err, ErrBodyTooLarge))
+}
+
+func TestSessionTimeout(t *testing.T) {
+	mock := newMockMatrix(t)
+	result, err := FetchMatrixResult(context.Background(), mock.Config(), FetchOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 15*time.Minute, result.SessionTimeout)
+	assert.Equal(t, len(result.Entries), result.ParseStats.Entries)
+	assert.True(t, result.ParseStats.Rows >= result.ParseStats.Entries)
+
+	result, err = FetchMatrixResult(context.Background(), mock.Config(), FetchOptions{SessionTimeout: 5 * time.Minute})
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Minute, result.SessionTimeout)
+
+	mock.RefreshSeconds = 1800
+	result, err = FetchMatrixResult(context.Background(), mock.Config(), FetchOptions{SessionTimeout: 5 * time.Minute})
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Minute, result.SessionTimeout)
+}
+
+func TestEvalSessionTimeout(t *testing.T) {
+	client := &MatrixClient{options: FetchOptions{}.withDefaults()}
+	client.sessionTimeout = client.options.SessionTimeout
+
+	// a refresh of the page itself is no session timeout
+	client.evalSessionTimeout(`<meta http-equiv="refresh" content="60;url=/matrix-v3.7.3.75487/mainMenu.jsf" />`)
+	assert.Equal(t, 15*time.Minute, client.SessionTimeout())
+
+	client.evalSessionTimeout(`<META HTTP-EQUIV="Refresh" CONTENT="600; URL=/matrix-v3.7.3.75487/login.jspx">`)
+	assert.Equal(t, 10*time.Minute, client.SessionTimeout())
+}
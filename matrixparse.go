@@ -0,0 +1,322 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	matrixBookingTableID = "mainbody:editWebBooking:logTable"
+)
+
+var (
+	// ErrBookingTableNotFound is returned when a page does not contain the booking table, e.g. because the page layout has changed.
+	ErrBookingTableNotFound = fmt.Errorf("booking table not found")
+	// ErrEntriesOutOfOrder is returned when the order check is enabled and a booking is listed after a later one, e.g. because the page layout has changed.
+	ErrEntriesOutOfOrder = fmt.Errorf("entries out of order")
+
+	patternBookingTypeID = regexp.MustCompile(`^mainbody:editWebBooking:logTable:\d+:logTypeOfBookingTable$`)
+	patternBookingTime   = regexp.MustCompile(`^(\d{1,2})[:.](\d{2})$`)
+)
+
+// RowError describes a booking row that could not be parsed.
+type RowError struct {
+	// Row is the 1-based index of the booking row in the table.
+	Row int
+	Err error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("booking row %d: %s", e.Row, e.Err.Error())
+}
+
+// Unwrap returns the underlying error.
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+// parseOptions controls how the booking table is parsed.
+type parseOptions struct {
+	// Location is the time zone of all entries.
+	Location *time.Location
+	// Strict aborts parsing at the first invalid row instead of skipping it.
+	Strict bool
+	// Keywords maps additional booking type labels to entry types. See FetchOptions.EntryTypeKeywords for details.
+	Keywords map[string]EntryType
+	// CheckOrder aborts parsing with ErrEntriesOutOfOrder if a booking time is before the previous one.
+	CheckOrder bool
+	// Stats is filled with the number of rows seen while parsing if not nil.
+	Stats *ParseStats
+}
+
+// ParseStats describes how many rows of the booking table have been recognized. A large difference between Rows and Entries indicates that the page layout has changed.
+type ParseStats struct {
+	// Rows is the number of data rows in the booking table.
+	Rows int
+	// BookingRows is the number of rows with a booking time and type.
+	BookingRows int
+	// Entries is the number of entries parsed from booking rows.
+	Entries int
+	// Ignored is the number of bookings that do not represent an entry, e.g. balance queries at the terminal.
+	Ignored int
+	// Skipped is the number of booking rows that could not be parsed.
+	Skipped int
+}
+
+// ParseEntries returns all entries listed in the booking table of a Matrix page, e.g. a page saved from the browser. This is the same logic used for fetched pages. Times are interpreted in the local time zone. The page must be UTF-8 encoded, fetched pages are converted from the declared character encoding before.
+// Rows that cannot be parsed are skipped and returned as joined RowErrors together with all other entries.
+func ParseEntries(r io.Reader) ([]Entry, error) {
+	return parseEntriesWith(r, parseOptions{Location: time.Local})
+}
+
+// ParseEntriesWithStats is like ParseEntries but also returns how many rows of the booking table have been recognized, e.g. to report changes of the page layout.
+func ParseEntriesWithStats(r io.Reader) ([]Entry, ParseStats, error) {
+	var stats ParseStats
+	entries, err := parseEntriesWith(r, parseOptions{Location: time.Local, Stats: &stats})
+	return entries, stats, err
+}
+
+// parseEntriesWith returns all entries listed in the booking table of a Matrix page. The entries parsed so far are also returned if some rows have been skipped.
+func parseEntriesWith(r io.Reader, options parseOptions) ([]Entry, error) {
+	entries := make([]Entry, 0)
+	err := parseEntriesFunc(r, options, func(entry Entry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil && (options.Strict || !isRowError(err)) {
+		return nil, err
+	}
+	return entries, err
+}
+
+// parseEntriesFromHTML returns all entries listed in the booking table of a Matrix page in the given location or time.Local if nil. It does not depend on anything but its input and the current day, so it is used as fuzz target.
+func parseEntriesFromHTML(body string, loc *time.Location) ([]Entry, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+	return parseEntriesWith(strings.NewReader(body), parseOptions{Location: loc})
+}
+
+// isRowError returns true if err only consists of RowErrors for skipped rows.
+func isRowError(err error) bool {
+	var rowErr *RowError
+	return errors.As(err, &rowErr)
+}
+
+// parseEntriesFunc calls fn for every entry listed in the booking table of a Matrix page. Times are interpreted as today in the configured location. Parsing stops at the first error returned by fn.
+// Invalid rows are skipped and returned as joined RowErrors after all other rows have been processed unless strict parsing is enabled.
+func parseEntriesFunc(r io.Reader, options parseOptions, fn func(Entry) error) error {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return fmt.Errorf("failed to parse html: %s", err.Error())
+	}
+
+	loc := options.Location
+	today := time.Now().In(loc)
+
+	table := findElement(doc, func(n *html.Node) bool { return getAttr(n, "id") == matrixBookingTableID })
+	if table == nil {
+		return ErrBookingTableNotFound
+	}
+
+	stats := options.Stats
+	if stats == nil {
+		stats = &ParseStats{}
+	}
+	*stats = ParseStats{}
+
+	bookingRow := 0
+	prevRow := 0
+	var prevTime time.Time
+	rowErrs := make([]error, 0)
+	for _, row := range findElements(table, "tr") {
+		if findElement(row, func(n *html.Node) bool { return n.Data == "td" }) != nil && !hasClass(row, "ui-datatable-empty-message") {
+			stats.Rows++
+		}
+
+		timeNode := findElement(row, func(n *html.Node) bool {
+			if n.Data != "span" || !hasClass(n, "dateTimeMinuteValue") {
+				return false
+			}
+			title := getAttr(n, "title")
+			return title == "Uhrzeit (SZ)" || title == "Time (ST)"
+		})
+		typeNode := findElement(row, func(n *html.Node) bool {
+			return n.Data == "span" && patternBookingTypeID.MatchString(getAttr(n, "id"))
+		})
+		if timeNode == nil || typeNode == nil {
+			// no booking row, e.g. table header
+			continue
+		}
+		bookingRow++
+		stats.BookingRows++
+
+		hour, minute, err := parseBookingTime(cellText(timeNode))
+		if err != nil {
+			stats.Skipped++
+			if options.Strict {
+				return &RowError{Row: bookingRow, Err: err}
+			}
+			rowErrs = append(rowErrs, &RowError{Row: bookingRow, Err: err})
+			continue
+		}
+		date := time.Date(today.Year(), today.Month(), today.Day(), hour, minute, 0, 0, loc)
+		if options.CheckOrder {
+			if prevRow > 0 && date.Before(prevTime) {
+				return fmt.Errorf("%w: booking row %d at %s is before booking row %d at %s", ErrEntriesOutOfOrder, bookingRow, date.Format("15:04"), prevRow, prevTime.Format("15:04"))
+			}
+			prevRow = bookingRow
+			prevTime = date
+		}
+
+		entryType, ok, err := parseEntryType(cellText(typeNode), options.Keywords)
+		if err != nil {
+			stats.Skipped++
+			if options.Strict {
+				return &RowError{Row: bookingRow, Err: err}
+			}
+			rowErrs = append(rowErrs, &RowError{Row: bookingRow, Err: err})
+			continue
+		}
+		if !ok {
+			stats.Ignored++
+			continue
+		}
+
+		stats.Entries++
+		if err := fn(Entry{Time: date, Type: entryType}); err != nil {
+			return err
+		}
+	}
+
+	if bookingRow == 0 && stats.Rows > 0 {
+		// an empty day has no rows at all, so unknown rows indicate a changed page layout
+		return fmt.Errorf("booking table contains %d rows without recognizable bookings", stats.Rows)
+	}
+	return errors.Join(rowErrs...)
+}
+
+// parseBookingTime returns hour and minute of a time like "07:58" or "7.58".
+func parseBookingTime(str string) (int, int, error) {
+	m := patternBookingTime.FindStringSubmatch(str)
+	if m == nil {
+		return 0, 0, fmt.Errorf("cannot parse time from %q", str)
+	}
+	hour, _ := strconv.Atoi(m[1])
+	minute, _ := strconv.Atoi(m[2])
+	if hour > 23 || minute > 59 {
+		return 0, 0, fmt.Errorf("time %q out of range", str)
+	}
+	return hour, minute, nil
+}
+
+// parseEntryType returns the entry type for a booking type label. The second return value is false for bookings that do not represent an entry.
+// Labels containing one of keywords take precedence over the built-in German and English labels.
+func parseEntryType(typeStr string, keywords map[string]EntryType) (EntryType, bool, error) {
+	lowerTypeStr := strings.ToLower(typeStr)
+	if keyword, ok := matchKeyword(lowerTypeStr, keywords); ok {
+		entryType := keywords[keyword]
+		return entryType, len(entryType) > 0, nil
+	}
+
+	if strings.Contains(lowerTypeStr, "pause") || strings.Contains(lowerTypeStr, "break") {
+		// "Pause Ende", "Pausenende", "Break end"
+		if strings.Contains(lowerTypeStr, "end") {
+			return EntryTypePauseEnd, true, nil
+		}
+		// "Pause Beginn", "Pausenbeginn", "Break start"
+		return EntryTypePauseStart, true, nil
+	} else if strings.Contains(lowerTypeStr, "kommen") || strings.Contains(lowerTypeStr, "arrive") {
+		return EntryTypeCome, true, nil
+	} else if strings.Contains(lowerTypeStr, "gehen") || strings.Contains(lowerTypeStr, "leave") || strings.Contains(lowerTypeStr, "hourly absence - end") {
+		return EntryTypeLeave, true, nil
+	} else if strings.Contains(lowerTypeStr, "???bookingtype.1034.name???") {
+		// "???BookingType.1034.name???" wird geschrieben, wenn man am Terminal den Kontostand abfragt
+		return "", false, nil
+	}
+	return "", false, fmt.Errorf("cannot parse entry type from %q", typeStr)
+}
+
+// matchKeyword returns the longest keyword that is contained in lowerTypeStr ignoring case. Keywords of equal length are compared alphabetically to be independent of the map order.
+func matchKeyword(lowerTypeStr string, keywords map[string]EntryType) (string, bool) {
+	match := ""
+	found := false
+	for keyword := range keywords {
+		if len(keyword) == 0 || !strings.Contains(lowerTypeStr, strings.ToLower(keyword)) {
+			continue
+		}
+		if !found || len(keyword) > len(match) || (len(keyword) == len(match) && keyword < match) {
+			match = keyword
+			found = true
+		}
+	}
+	return match, found
+}
+
+// findElements returns all element nodes below n with the given tag name in document order.
+func findElements(n *html.Node, tag string) []*html.Node {
+	nodes := make([]*html.Node, 0)
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.Data == tag {
+			nodes = append(nodes, c)
+		}
+		nodes = append(nodes, findElements(c, tag)...)
+	}
+	return nodes
+}
+
+// findElement returns the first element node below n that satisfies match or nil if there is none.
+func findElement(n *html.Node, match func(*html.Node) bool) *html.Node {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && match(c) {
+			return c
+		}
+		if found := findElement(c, match); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func getAttr(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+func hasClass(n *html.Node, class string) bool {
+	for _, c := range strings.Fields(getAttr(n, "class")) {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// cellText returns the trimmed text of a table cell. Entities are decoded once more because some pages contain double encoded text like "&amp;nbsp;", and non-breaking spaces are treated as regular spaces.
+func cellText(n *html.Node) string {
+	text := html.UnescapeString(textContent(n))
+	return strings.TrimSpace(strings.ReplaceAll(text, "\u00a0", " "))
+}
+
+// textContent returns the concatenated text of all text nodes below n.
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var sb strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		sb.WriteString(textContent(c))
+	}
+	return sb.String()
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type parseEntriesCase struct {
+	File    string
+	Entries []Entry
+}
+
+func TestParseEntries(t *testing.T) {
+	testCases := []parseEntriesCase{
+		{File: "entries_default.html", Entries: []Entry{
+			{Type: EntryTypeCome, Time: today(7, 58)},
+			{Type: EntryTypeLeave, Time: today(12, 3)},
+			{Type: EntryTypeCome, Time: today(12, 44)},
+		}},
+		{File: "entries_reformatted.html", Entries: []Entry{
+			{Type: EntryTypeCome, Time: today(8, 12)},
+			{Type: EntryTypeLeave, Time: today(16, 47)},
+		}},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.File, func(t *testing.T) {
+			entries, err := parseEntriesFromHTML(readFixture(t, c.File), time.Local)
+			require.NoError(t, err)
+			assert.Equal(t, c.Entries, entries)
+		})
+	}
+}
+
+func TestParseEntriesEmptyDay(t *testing.T) {
+	entries, err := parseEntriesFromHTML(readFixture(t, "entries_empty.html"), time.Local)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestParseEntriesMissingTable(t *testing.T) {
+	_, err := parseEntriesFromHTML(readFixture(t, "login.html"), time.Local)
+	assert.True(t, errors.Is(err, ErrBookingTableNotFound))
+
+	_, err = parseEntriesFromHTML(readFixture(t, "entries_changed_layout.html"), time.Local)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "4 rows")
+}
+
+func TestParseEntriesWithPause(t *testing.T) {
+	entries, err := parseEntriesFromHTML(readFixture(t, "entries_pause.html"), time.Local)
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{
+		{Type: EntryTypeCome, Time: today(8, 0)},
+		{Type: EntryTypePauseStart, Time: today(12, 0)},
+		{Type: EntryTypePauseEnd, Time: today(12, 30)},
+		{Type: EntryTypeLeave, Time: today(16, 45)},
+	}, entries)
+
+	worked, err := WorkedDuration(entries)
+	require.NoError(t, err)
+	assert.Equal(t, 8*time.Hour+15*time.Minute, worked)
+}
+
+func TestParseEntriesReader(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "entries_reformatted.html"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	entries, err := ParseEntries(f)
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{
+		{Type: EntryTypeCome, Time: today(8, 12)},
+		{Type: EntryTypeLeave, Time: today(16, 47)},
+	}, entries)
+}
+
+func readFixture(t *testing.T, file string) string {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", file))
+	require.NoError(t, err)
+	return string(data)
+}
+
+func today(hours, minutes int) time.Time {
+	now := time.Now()
+	return time.Date(now.Year(), now.Month(), now.Day(), hours, minutes, 0, 0, time.Local)
+}
+
+type bookingTimeCase struct {
+	Input       string
+	Hour        int
+	Minute      int
+	ExpectError bool
+}
+
+func TestParseBookingTime(t *testing.T) {
+	testCases := []bookingTimeCase{
+		{Input: "07:58", Hour: 7, Minute: 58},
+		{Input: "7:58", Hour: 7, Minute: 58},
+		{Input: "7.05", Hour: 7, Minute: 5},
+		{Input: "7:5", ExpectError: true},
+		{Input: "7.5", ExpectError: true},
+		{Input: "23:59", Hour: 23, Minute: 59},
+		{Input: "24:00", ExpectError: true},
+		{Input: "12:60", ExpectError: true},
+		{Input: "12", ExpectError: true},
+		{Input: "12:3x", ExpectError: true},
+		{Input: "", ExpectError: true},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.Input, func(t *testing.T) {
+			hour, minute, err := parseBookingTime(c.Input)
+			if c.ExpectError {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, c.Hour, hour)
+				assert.Equal(t, c.Minute, minute)
+			}
+		})
+	}
+}
+
+func TestParseEntriesWithStats(t *testing.T) {
+	entries, stats, err := ParseEntriesWithStats(strings.NewReader(readFixture(t, "entries_default.html")))
+	require.NoError(t, err)
+	assert.Len(t, entries, 3)
+	assert.Equal(t, ParseStats{Rows: 4, BookingRows: 4, Entries: 3, Ignored: 1}, stats)
+
+	body := strings.Replace(readFixture(t, "entries_default.html"), "12:03", "12:63", 1)
+	_, stats, err = ParseEntriesWithStats(strings.NewReader(body))
+	require.Error(t, err)
+	assert.Equal(t, ParseStats{Rows: 4, BookingRows: 4, Entries: 2, Ignored: 1, Skipped: 1}, stats)
+
+	// rows without recognizable bookings are only counted as rows
+	body = strings.Replace(readFixture(t, "entries_default.html"), "dateTimeMinuteValue", "minuteValue", -1)
+	_, stats, err = ParseEntriesWithStats(strings.NewReader(body))
+	require.Error(t, err)
+	assert.Equal(t, ParseStats{Rows: 4}, stats)
+}
+
+func TestParseEntriesInvalidRows(t *testing.T) {
+	body := strings.Replace(readFixture(t, "entries_default.html"), "12:03", "12:63", 1)
+	body = strings.Replace(body, ">Kommen</span></td></tr>\n<tr data-ri=\"1\"", ">Dienstgang</span></td></tr>\n<tr data-ri=\"1\"", 1)
+
+	entries, err := parseEntriesFromHTML(body, time.Local)
+	require.Error(t, err)
+	assert.Equal(t, []Entry{{Type: EntryTypeCome, Time: today(12, 44)}}, entries)
+	assert.Contains(t, err.Error(), "booking row 1")
+	assert.Contains(t, err.Error(), "booking row 2")
+	var rowErr *RowError
+	require.True(t, errors.As(err, &rowErr))
+	assert.Equal(t, 1, rowErr.Row)
+
+	entries, err = parseEntriesWith(strings.NewReader(body), parseOptions{Location: time.Local, Strict: true})
+	require.Error(t, err)
+	assert.Nil(t, entries)
+	require.True(t, errors.As(err, &rowErr))
+	assert.Equal(t, 1, rowErr.Row)
+	assert.NotContains(t, err.Error(), "booking row 2")
+}
+
+func TestParseEntriesLocation(t *testing.T) {
+	loc := time.FixedZone("UTC-10", -10*3600)
+	entries, err := parseEntriesWith(strings.NewReader(readFixture(t, "entries_reformatted.html")), parseOptions{Location: loc})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	now := time.Now().In(loc)
+	assert.Equal(t, time.Date(now.Year(), now.Month(), now.Day(), 8, 12, 0, 0, loc), entries[0].Time)
+	assert.Equal(t, loc, entries[0].Time.Location())
+}
+
+type entryTypeCase struct {
+	Label   string
+	Type    EntryType
+	Ignored bool
+}
+
+func TestParseEntryTypeKeywords(t *testing.T) {
+	keywords := map[string]EntryType{
+		"entrée":       EntryTypeCome,
+		"sortie":       EntryTypeLeave,
+		"sortie pause": EntryTypePauseStart,
+		"terminal":     "",
+	}
+	testCases := []entryTypeCase{
+		{Label: "Entrée", Type: EntryTypeCome},
+		{Label: "SORTIE", Type: EntryTypeLeave},
+		{Label: "Sortie pause", Type: EntryTypePauseStart},
+		{Label: "Solde terminal", Ignored: true},
+		// built-in labels are still recognized
+		{Label: "Kommen", Type: EntryTypeCome},
+		{Label: "Pause Ende", Type: EntryTypePauseEnd},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.Label, func(t *testing.T) {
+			entryType, ok, err := parseEntryType(c.Label, keywords)
+			require.NoError(t, err)
+			assert.Equal(t, !c.Ignored, ok)
+			assert.Equal(t, c.Type, entryType)
+		})
+	}
+
+	_, _, err := parseEntryType("Mission", keywords)
+	assert.Error(t, err)
+}
+
+func TestParseEntriesKeywords(t *testing.T) {
+	body := strings.Replace(readFixture(t, "entries_reformatted.html"), ">Arrive<", ">Entrée<", 1)
+	body = strings.Replace(body, ">Leave<", ">Sortie<", 1)
+
+	_, err := parseEntriesWith(strings.NewReader(body), parseOptions{Location: time.Local, Strict: true})
+	assert.Error(t, err)
+
+	entries, err := parseEntriesWith(strings.NewReader(body), parseOptions{Location: time.Local, Keywords: map[string]EntryType{"entrée": EntryTypeCome, "sortie": EntryTypeLeave}})
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{
+		{Type: EntryTypeCome, Time: today(8, 12)},
+		{Type: EntryTypeLeave, Time: today(16, 47)},
+	}, entries)
+}
+
+func TestParseEntriesCheckOrder(t *testing.T) {
+	body := readFixture(t, "entries_default.html")
+	entries, err := parseEntriesWith(strings.NewReader(body), parseOptions{Location: time.Local, CheckOrder: true})
+	require.NoError(t, err)
+	assert.Len(t, entries, 3)
+
+	// the last booking at 12:44 is moved before the ignored terminal booking at 12:41
+	body = strings.Replace(body, "12:44", "11:30", 1)
+	entries, err = parseEntriesWith(strings.NewReader(body), parseOptions{Location: time.Local})
+	require.NoError(t, err)
+	assert.Len(t, entries, 3)
+
+	entries, err = parseEntriesWith(strings.NewReader(body), parseOptions{Location: time.Local, CheckOrder: true})
+	assert.True(t, errors.Is(err, ErrEntriesOutOfOrder))
+	assert.Contains(t, err.Error(), "booking row 4 at 11:30 is before booking row 3 at 12:41")
+	assert.Nil(t, entries)
+}
+
+func FuzzParseEntriesFromHTML(f *testing.F) {
+	for _, file := range []string{"entries_default.html", "entries_reformatted.html", "entries_pause.html", "entries_empty.html", "entries_changed_layout.html", "login.html"} {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", file))
+		require.NoError(f, err)
+		f.Add(string(data))
+	}
+	f.Add(`<div id="mainbody:editWebBooking:logTable"><table><tr><td><span class="dateTimeMinuteValue" title="Uhrzeit (SZ)">99:99</span></td></tr></table></div>`)
+
+	f.Fuzz(func(t *testing.T, body string) {
+		entries, err := parseEntriesFromHTML(body, time.UTC)
+		if err == nil && entries == nil {
+			t.Fatal("neither entries nor error returned")
+		}
+		for _, entry := range entries {
+			if entry.Time.Location() != time.UTC {
+				t.Fatalf("entry %v not in requested location", entry)
+			}
+		}
+	})
+}
+
+func TestParseEntriesEntities(t *testing.T) {
+	entries, err := parseEntriesFromHTML(readFixture(t, "entries_entities.html"), time.Local)
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{
+		{Type: EntryTypeCome, Time: today(7, 45)},
+		{Type: EntryTypePauseStart, Time: today(12, 0)},
+		{Type: EntryTypePauseEnd, Time: today(12, 30)},
+		{Type: EntryTypeLeave, Time: today(16, 30)},
+	}, entries)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	descWorkedSecondsToday = prometheus.NewDesc("gohome_worked_seconds_today", "Work time of the current day in seconds including an open work session.", nil, nil)
+	descIsClockedIn        = prometheus.NewDesc("gohome_is_clocked_in", "1 if the last entry starts a work session, 0 otherwise.", nil, nil)
+	descLastEntryTimestamp = prometheus.NewDesc("gohome_last_entry_timestamp", "Unix time of the last entry in seconds.", nil, nil)
+)
+
+// EntryCollector is a prometheus.Collector that exposes metrics derived from the entries of the current day. Values are computed on every scrape.
+type EntryCollector struct {
+	mutex   sync.Mutex
+	entries []Entry
+}
+
+// NewEntryCollector returns a new collector for the given entries.
+func NewEntryCollector(entries []Entry) *EntryCollector {
+	c := &EntryCollector{}
+	c.Update(entries)
+	return c
+}
+
+// Update replaces the entries used to compute the metrics, e.g. after fetching them again.
+func (c *EntryCollector) Update(entries []Entry) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries = append([]Entry(nil), entries...)
+}
+
+// Describe implements prometheus.Collector.
+func (c *EntryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- descWorkedSecondsToday
+	ch <- descIsClockedIn
+	ch <- descLastEntryTimestamp
+}
+
+// Collect implements prometheus.Collector.
+func (c *EntryCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	worked, err := WorkedDuration(c.entries)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(descWorkedSecondsToday, err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(descWorkedSecondsToday, prometheus.GaugeValue, worked.Seconds())
+	}
+
+	clockedIn := 0.0
+	if IsClockedIn(c.entries) {
+		clockedIn = 1
+	}
+	ch <- prometheus.MustNewConstMetric(descIsClockedIn, prometheus.GaugeValue, clockedIn)
+
+	if len(c.entries) > 0 {
+		last := c.entries[len(c.entries)-1].Time
+		ch <- prometheus.MustNewConstMetric(descLastEntryTimestamp, prometheus.GaugeValue, float64(last.Unix()))
+	}
+}
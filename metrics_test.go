@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEntryCollector(t *testing.T) {
+	collector := NewEntryCollector([]Entry{come(8, 0), leave(12, 0), come(12, 30), leave(16, 45)})
+	registry := prometheus.NewPedanticRegistry()
+	require.NoError(t, registry.Register(collector))
+
+	expected := `
+# HELP gohome_is_clocked_in 1 if the last entry starts a work session, 0 otherwise.
+# TYPE gohome_is_clocked_in gauge
+gohome_is_clocked_in 0
+# HELP gohome_last_entry_timestamp Unix time of the last entry in seconds.
+# TYPE gohome_last_entry_timestamp gauge
+gohome_last_entry_timestamp 1.5726267e+09
+# HELP gohome_worked_seconds_today Work time of the current day in seconds including an open work session.
+# TYPE gohome_worked_seconds_today gauge
+gohome_worked_seconds_today 29700
+`
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected)))
+
+	collector.Update([]Entry{come(8, 0)})
+	expected = `
+# HELP gohome_is_clocked_in 1 if the last entry starts a work session, 0 otherwise.
+# TYPE gohome_is_clocked_in gauge
+gohome_is_clocked_in 1
+`
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "gohome_is_clocked_in"))
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	mockMatrixPrefix = "/matrix-v3.7.3.75487"
+	// mockAffinityCookie emulates a load balancer that requires its own cookie in addition to the session cookie.
+	mockAffinityCookie = "ROUTEID"
+)
+
+var (
+	// mockTimeZoneCookies must be sent with the booking page request like the login form sends its time zone fields.
+	mockTimeZoneCookies = map[string]string{"timezonedst": "true", "timezonename": "Europe/Berlin", "timezoneoffset": "+01:00"}
+)
+
+// mockMatrix is a fake Matrix server that implements the login and navigation flow used by MatrixClient and serves pages from testdata.
+type mockMatrix struct {
+	*httptest.Server
+
+	User string
+	Pass string
+	// Prefix is the path of the Matrix application on the server.
+	Prefix string
+	// RefreshSeconds adds a meta refresh to the login page after this many seconds to every page if positive.
+	RefreshSeconds int
+	// LoginRedirect emulates a reverse proxy that redirects the login to this URL without setting a session cookie.
+	LoginRedirect string
+	// EntriesFile and MonthReconFile are served as booking page and monthly reconciliation.
+	EntriesFile    string
+	MonthReconFile string
+
+	mutex     sync.Mutex
+	sessions  map[string]bool
+	nextID    int
+	requests  int
+	agents    map[string]bool
+	failNext  int
+	failCode  int
+	testState *testing.T
+}
+
+// newMockMatrix starts a fake Matrix server that is closed when the test ends.
+func newMockMatrix(t *testing.T) *mockMatrix {
+	m := &mockMatrix{
+		User:           "jdoe",
+		Pass:           "secret",
+		Prefix:         mockMatrixPrefix,
+		EntriesFile:    "entries_default.html",
+		MonthReconFile: "monthrecon.html",
+		sessions:       make(map[string]bool),
+		agents:         make(map[string]bool),
+		testState:      t,
+	}
+	m.Server = httptest.NewServer(http.HandlerFunc(m.handle))
+	t.Cleanup(m.Close)
+	return m
+}
+
+// Config returns a configuration with valid credentials for the mock.
+func (m *mockMatrix) Config() MatrixConfig {
+	return MatrixConfig{Host: m.URL, User: m.User, Pass: m.Pass}
+}
+
+// ExpireSessions invalidates all sessions, so the next request is redirected to the login page.
+func (m *mockMatrix) ExpireSessions() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.sessions = make(map[string]bool)
+}
+
+// SetEntriesFile changes the booking page served for following requests.
+func (m *mockMatrix) SetEntriesFile(file string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.EntriesFile = file
+}
+
+// FailNext lets the next count requests fail with the given status code.
+func (m *mockMatrix) FailNext(count, code int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.failNext = count
+	m.failCode = code
+}
+
+// Requests returns the number of requests received so far.
+func (m *mockMatrix) Requests() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.requests
+}
+
+// UserAgents returns all distinct user agents seen so far.
+func (m *mockMatrix) UserAgents() []string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	agents := make([]string, 0, len(m.agents))
+	for agent := range m.agents {
+		agents = append(agents, agent)
+	}
+	return agents
+}
+
+func (m *mockMatrix) handle(w http.ResponseWriter, r *http.Request) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.requests++
+	m.agents[r.UserAgent()] = true
+	if m.failNext > 0 {
+		m.failNext--
+		w.WriteHeader(m.failCode)
+		return
+	}
+
+	if r.URL.Path == m.Prefix+"/login.jspx" {
+		m.handleLogin(w, r)
+		return
+	}
+
+	cookie, err := r.Cookie(matrixSessionCookieName)
+	_, affinityErr := r.Cookie(mockAffinityCookie)
+	if err != nil || affinityErr != nil || !m.sessions[cookie.Value] {
+		if r.Method == http.MethodPost {
+			redirect(w, m.Prefix+"/login.jspx")
+		} else {
+			m.writePage(w, "login.html")
+		}
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		switch r.PostFormValue("activateMenuItem") {
+		case "mss_root":
+			redirect(w, m.Prefix+"/selfService.jsf")
+		case "tim_searchWebBookingMss":
+			redirect(w, m.Prefix+"/bookings.jsf")
+		case "tim_persMonthlyReconciliation":
+			redirect(w, m.Prefix+"/monthRecon.jsf")
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		return
+	}
+
+	switch r.URL.Path {
+	case m.Prefix + "/mainMenu.jsf", m.Prefix + "/selfService.jsf":
+		m.writePage(w, "")
+	case m.Prefix + "/bookings.jsf":
+		for name, value := range mockTimeZoneCookies {
+			if cookie, err := r.Cookie(name); err != nil || cookie.Value != value {
+				m.testState.Errorf("booking page requested without cookie %s=%s", name, value)
+			}
+		}
+		m.writePage(w, m.EntriesFile)
+	case m.Prefix + "/monthRecon.jsf":
+		m.writePage(w, m.MonthReconFile)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (m *mockMatrix) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if len(m.LoginRedirect) > 0 {
+		redirect(w, m.LoginRedirect)
+		return
+	}
+	if r.PostFormValue("userid") != m.User || r.PostFormValue("password") != m.Pass {
+		// Matrix renders the login form again on wrong credentials
+		m.writePage(w, "login.html")
+		return
+	}
+
+	m.nextID++
+	sessionID := fmt.Sprintf("SESSION%04d", m.nextID)
+	m.sessions[sessionID] = true
+	http.SetCookie(w, &http.Cookie{Name: matrixSessionCookieName, Value: sessionID, Path: "/"})
+	http.SetCookie(w, &http.Cookie{Name: mockAffinityCookie, Value: ".node1", Path: "/"})
+	redirect(w, m.Prefix+"/mainMenu.jsf")
+}
+
+// writePage writes a page from testdata followed by the navigation state that MatrixClient expects on every page.
+func (m *mockMatrix) writePage(w http.ResponseWriter, file string) {
+	content := ""
+	if len(file) > 0 {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", file))
+		require.NoError(m.testState, err)
+		content = string(data)
+	}
+
+	head := ""
+	if m.RefreshSeconds > 0 {
+		head = fmt.Sprintf(`<meta http-equiv="refresh" content="%d;url=%s/login.jspx?expired=true&amp;reason=timeout" />`, m.RefreshSeconds, m.Prefix)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, `<html><head>%s</head><body>
+%s
+<form id="menuform">
+<input type="hidden" name="uniqueToken" value="token%d" />
+<input type="hidden" name="javax.faces.ViewState" id="javax.faces.ViewState:0" value="state%d" />
+</form>
+<script>
+activate({'tim_searchWebBookingMss','menuform:mainMenu_mss_root_menuid':'11'});
+activate({'tim_persMonthlyReconciliation','menuform:mainMenu_mss_root_menuid':'12'});
+</script>
+</body></html>`, head, content, m.requests, m.requests)
+}
+
+func redirect(w http.ResponseWriter, location string) {
+	w.Header().Set("Location", location)
+	w.WriteHeader(http.StatusFound)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// netrcMachine is a single machine or default entry of a .netrc file.
+type netrcMachine struct {
+	Name     string
+	Login    string
+	Password string
+	// IsDefault is true for the "default" entry that matches all machines.
+	IsDefault bool
+}
+
+// getNetrcFile returns the path of the .netrc file from NETRC or the home directory.
+func getNetrcFile(getenv func(string) string, homeDir func() (string, error)) (string, error) {
+	if file := getenv("NETRC"); len(file) > 0 {
+		return file, nil
+	}
+	home, err := homeDir()
+	if err != nil {
+		return "", err
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(home, "_netrc"), nil
+	}
+	return filepath.Join(home, ".netrc"), nil
+}
+
+// parseNetrc returns all machine and default entries. Macro definitions and comments are skipped.
+func parseNetrc(data string) []netrcMachine {
+	machines := make([]netrcMachine, 0)
+	var current *netrcMachine
+	inMacdef := false
+
+	for _, line := range strings.Split(data, "\n") {
+		if inMacdef {
+			// a macro definition ends with an empty line
+			if len(strings.TrimSpace(line)) == 0 {
+				inMacdef = false
+			}
+			continue
+		}
+
+		tokens := strings.Fields(line)
+		for i := 0; i < len(tokens); i++ {
+			if strings.HasPrefix(tokens[i], "#") {
+				break
+			}
+
+			next := ""
+			if i+1 < len(tokens) {
+				next = tokens[i+1]
+			}
+			switch tokens[i] {
+			case "machine":
+				machines = append(machines, netrcMachine{Name: next})
+				current = &machines[len(machines)-1]
+				i++
+			case "default":
+				machines = append(machines, netrcMachine{IsDefault: true})
+				current = &machines[len(machines)-1]
+			case "login":
+				if current != nil {
+					current.Login = next
+				}
+				i++
+			case "password":
+				if current != nil {
+					current.Password = next
+				}
+				i++
+			case "account":
+				i++
+			case "macdef":
+				inMacdef = true
+				i = len(tokens)
+			}
+		}
+	}
+	return machines
+}
+
+// findNetrcMachine returns the first entry for host and user. The default entry is only used if no machine matches and useDefault is true. An empty user matches every login.
+func findNetrcMachine(machines []netrcMachine, host, user string, useDefault bool) (netrcMachine, bool) {
+	var fallback *netrcMachine
+	for i, m := range machines {
+		if len(user) > 0 && m.Login != user {
+			continue
+		}
+		if m.IsDefault {
+			if useDefault && fallback == nil {
+				fallback = &machines[i]
+			}
+			continue
+		}
+		if strings.EqualFold(m.Name, host) {
+			return m, true
+		}
+	}
+	if fallback != nil {
+		return *fallback, true
+	}
+	return netrcMachine{}, false
+}
+
+// applyNetrc fills missing user and password of config from the .netrc file. The config is left unchanged if the file does not exist or contains no matching entry. The default entry is skipped if useDefault is false.
+func applyNetrc(config *MatrixConfig, useDefault bool) {
+	file, err := getNetrcFile(os.Getenv, func() (string, error) { return os.UserHomeDir() })
+	if err != nil {
+		return
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return
+	}
+
+	u, err := url.Parse(config.Host)
+	if err != nil {
+		return
+	}
+	m, ok := findNetrcMachine(parseNetrc(string(data)), u.Hostname(), config.User, useDefault)
+	if !ok || len(m.Password) == 0 {
+		return
+	}
+
+	warnPermissiveMode(file)
+	if len(config.User) == 0 {
+		config.User = m.Login
+	}
+	config.Pass = m.Password
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testNetrc = `# work accounts
+machine matrix.example.com login jdoe password secret
+machine other.example.com
+	login jane
+	password s3cret
+
+macdef init
+machine macro.example.com login evil password evil
+
+default login anonymous password guest
+`
+
+func TestParseNetrc(t *testing.T) {
+	assert.Equal(t, []netrcMachine{
+		{Name: "matrix.example.com", Login: "jdoe", Password: "secret"},
+		{Name: "other.example.com", Login: "jane", Password: "s3cret"},
+		{Login: "anonymous", Password: "guest", IsDefault: true},
+	}, parseNetrc(testNetrc))
+}
+
+type netrcLookupCase struct {
+	Host      string
+	User      string
+	NoDefault bool
+	Password  string
+	Found     bool
+}
+
+func TestFindNetrcMachine(t *testing.T) {
+	machines := parseNetrc(testNetrc)
+	testCases := []netrcLookupCase{
+		{Host: "matrix.example.com", Password: "secret", Found: true},
+		{Host: "MATRIX.example.com", User: "jdoe", Password: "secret", Found: true},
+		{Host: "matrix.example.com", User: "other", Found: false},
+		{Host: "unknown.example.com", Password: "guest", Found: true},
+		{Host: "unknown.example.com", User: "anonymous", Password: "guest", Found: true},
+		{Host: "unknown.example.com", NoDefault: true, Found: false},
+		{Host: "matrix.example.com", NoDefault: true, Password: "secret", Found: true},
+	}
+
+	for _, c := range testCases {
+		t.Run(fmt.Sprintf("%s-%s-%v", c.Host, c.User, c.NoDefault), func(t *testing.T) {
+			m, ok := findNetrcMachine(machines, c.Host, c.User, !c.NoDefault)
+			assert.Equal(t, c.Found, ok)
+			assert.Equal(t, c.Password, m.Password)
+		})
+	}
+}
+
+func TestGetNetrcFile(t *testing.T) {
+	file, err := getNetrcFile(func(key string) string {
+		if key == "NETRC" {
+			return "/etc/gohome/netrc"
+		}
+		return ""
+	}, func() (string, error) { return "/home/jdoe", nil })
+	assert.NoError(t, err)
+	assert.Equal(t, "/etc/gohome/netrc", file)
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sbreitf1/go-jcrypt"
+)
+
+// ListProfileHosts is the same as Config.ListProfileHosts using DefaultConfig.
+func ListProfileHosts() (map[string]string, error) {
+	config, err := DefaultConfig()
+	if err != nil {
+		return nil, err
+	}
+	return config.ListProfileHosts()
+}
+
+// ListProfileHosts returns the Matrix host of every profile stored in the config directory. Profiles stored with KeyringCredentialStore are not listed.
+func (c Config) ListProfileHosts() (map[string]string, error) {
+	configDir, err := c.dir()
+	if err != nil {
+		return nil, err
+	}
+	return listProfileHosts(configDir)
+}
+
+// SetProfileHost is the same as Config.SetProfileHost using DefaultConfig.
+func SetProfileHost(profile, host string) error {
+	config, err := DefaultConfig()
+	if err != nil {
+		return err
+	}
+	return config.SetProfileHost(profile, host)
+}
+
+// SetProfileHost changes the Matrix host of a stored profile. A new profile without credentials is created if it does not exist yet.
+func (c Config) SetProfileHost(profile, host string) error {
+	configDir, err := c.dir()
+	if err != nil {
+		return err
+	}
+	return setProfileHost(configDir, profile, host)
+}
+
+// RemoveProfile is the same as Config.RemoveProfile using DefaultConfig.
+func RemoveProfile(profile string) error {
+	config, err := DefaultConfig()
+	if err != nil {
+		return err
+	}
+	return config.RemoveProfile(profile)
+}
+
+// RemoveProfile deletes the stored configuration of a profile including its credentials.
+func (c Config) RemoveProfile(profile string) error {
+	configDir, err := c.dir()
+	if err != nil {
+		return err
+	}
+	return removeProfile(configDir, profile)
+}
+
+func listProfileHosts(configDir string) (map[string]string, error) {
+	files, err := ioutil.ReadDir(configDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]string{}, nil
+		}
+		return nil, err
+	}
+
+	hosts := make(map[string]string)
+	for _, f := range files {
+		profile, ok := profileFromFileName(f.Name())
+		if !ok || f.IsDir() {
+			continue
+		}
+
+		raw, err := readRawProfile(filepath.Join(configDir, f.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read profile %q: %s", profile, err.Error())
+		}
+		host, _ := raw["host"].(string)
+		hosts[profile] = host
+	}
+	return hosts, nil
+}
+
+// profileFromFileName returns the profile of a configuration file name as created by getProfileFile.
+func profileFromFileName(name string) (string, bool) {
+	if name == "matrix.json" {
+		return DefaultProfile, true
+	}
+	if !strings.HasPrefix(name, "matrix-") || !strings.HasSuffix(name, ".json") {
+		return "", false
+	}
+	profile := strings.TrimSuffix(strings.TrimPrefix(name, "matrix-"), ".json")
+	if !patternProfileName.MatchString(profile) {
+		return "", false
+	}
+	return profile, true
+}
+
+func setProfileHost(configDir, profile, host string) error {
+	host, err := normalizeHost(host)
+	if err != nil {
+		return err
+	}
+	configFile, err := getProfileFile(configDir, profile)
+	if err != nil {
+		return err
+	}
+
+	raw, err := readRawProfile(configFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return err
+		}
+		raw = map[string]interface{}{"version": profileFileVersion}
+	}
+	// the encrypted password is kept as it is, because jcrypt encrypts every field on its own
+	raw["host"] = host
+
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(configDir, configDirPerm); err != nil {
+		return err
+	}
+	return writePrivateFile(configFile, data)
+}
+
+func removeProfile(configDir, profile string) error {
+	configFile, err := getProfileFile(configDir, profile)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(configFile); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("profile %q does not exist", profile)
+		}
+		return err
+	}
+	return nil
+}
+
+// readRawProfile returns the JSON object of a configuration file without decrypting the password.
+func readRawProfile(file string) (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return readRawProfileData(data)
+}
+
+func readRawProfileData(data []byte) (map[string]interface{}, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+// ListCredentialHosts is the same as Config.ListCredentialHosts using DefaultConfig.
+func ListCredentialHosts() ([]string, error) {
+	config, err := DefaultConfig()
+	if err != nil {
+		return nil, err
+	}
+	return config.ListCredentialHosts()
+}
+
+// ListCredentialHosts returns all Matrix hosts with a stored password in alphabetical order.
+func (c Config) ListCredentialHosts() ([]string, error) {
+	configDir, err := c.dir()
+	if err != nil {
+		return nil, err
+	}
+	return listCredentialHosts(configDir)
+}
+
+// SetCredential is the same as Config.SetCredential using DefaultConfig.
+func SetCredential(host, user, pass string) error {
+	config, err := DefaultConfig()
+	if err != nil {
+		return err
+	}
+	return config.SetCredential(host, user, pass)
+}
+
+// SetCredential changes user and password of all profiles for a Matrix host. An existing passphrase of a profile is asked for and kept.
+func (c Config) SetCredential(host, user, pass string) error {
+	configDir, err := c.dir()
+	if err != nil {
+		return err
+	}
+	return setCredential(configDir, orDefaultPrompter(c.Prompter), host, user, pass)
+}
+
+// RemoveCredential is the same as Config.RemoveCredential using DefaultConfig.
+func RemoveCredential(host string) error {
+	config, err := DefaultConfig()
+	if err != nil {
+		return err
+	}
+	return config.RemoveCredential(host)
+}
+
+// RemoveCredential deletes the stored password of all profiles for a Matrix host. The password will be asked for on every run afterwards.
+func (c Config) RemoveCredential(host string) error {
+	configDir, err := c.dir()
+	if err != nil {
+		return err
+	}
+	return removeCredential(configDir, host)
+}
+
+func listCredentialHosts(configDir string) ([]string, error) {
+	files, err := findProfileFiles(configDir, "")
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[string]bool)
+	hosts := make([]string, 0)
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		host, ok := storedPasswordHost(data)
+		if !ok || known[host] {
+			continue
+		}
+		known[host] = true
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts, nil
+}
+
+// storedPasswordHost returns the host of a configuration file. The second return value is false if no password is stored. Passwords protected by a passphrase are assumed to be non-empty.
+func storedPasswordHost(data []byte) (string, bool) {
+	var config MatrixConfig
+	err := jcrypt.Unmarshal(data, &config, &jcrypt.Options{GetKeyHandler: jcrypt.StaticKey(key)})
+	if err != nil {
+		if !jcrypt.IsWrongPassword(err) {
+			return "", false
+		}
+		raw, err := readRawProfileData(data)
+		if err != nil {
+			return "", false
+		}
+		host, _ := raw["host"].(string)
+		return host, true
+	}
+	return config.Host, len(config.Pass) > 0
+}
+
+func setCredential(configDir string, prompter Prompter, host, user, pass string) error {
+	files, err := findProfileFiles(configDir, host)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no profile for host %q", host)
+	}
+
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		config, passphrase, err := unmarshalMatrixConfig(data, prompter)
+		if err != nil {
+			return err
+		}
+		config.User = user
+		config.Pass = pass
+		if err := writeMatrixConfig(file, config, passphrase); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func removeCredential(configDir, host string) error {
+	files, err := findProfileFiles(configDir, host)
+	if err != nil {
+		return err
+	}
+
+	removed := false
+	for _, file := range files {
+		ok, err := removeStoredPassword(file)
+		if err != nil {
+			return err
+		}
+		removed = removed || ok
+	}
+	if !removed {
+		return fmt.Errorf("no stored password for host %q", host)
+	}
+	return nil
+}
+
+// removeStoredPassword deletes the password from a configuration file. It returns false if no password is stored.
+func removeStoredPassword(file string) (bool, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := storedPasswordHost(data); !ok {
+		return false, nil
+	}
+	raw, err := readRawProfileData(data)
+	if err != nil {
+		return false, err
+	}
+	delete(raw, "pass")
+
+	data, err = json.Marshal(raw)
+	if err != nil {
+		return false, err
+	}
+	if err := writePrivateFile(file, data); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// findProfileFiles returns the configuration files of all profiles for a Matrix host. All profiles are returned if host is empty.
+func findProfileFiles(configDir, host string) ([]string, error) {
+	if len(host) > 0 {
+		var err error
+		host, err = normalizeHost(host)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	hosts, err := listProfileHosts(configDir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0)
+	for profile, profileHost := range hosts {
+		if len(host) > 0 {
+			if normalized, err := normalizeHost(profileHost); err != nil || !strings.EqualFold(normalized, host) {
+				continue
+			}
+		}
+		file, err := getProfileFile(configDir, profile)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/sbreitf1/go-jcrypt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfileHosts(t *testing.T) {
+	configDir := t.TempDir()
+	require.NoError(t, writeMatrixConfig(filepath.Join(configDir, "matrix.json"), MatrixConfig{Host: "https://matrix.example.com", User: "jdoe", Pass: "secret"}, key))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(configDir, "session.json"), []byte(`{}`), configFilePerm))
+
+	require.NoError(t, setProfileHost(configDir, "work", "other.example.com:8443/matrix"))
+	hosts, err := listProfileHosts(configDir)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"default": "https://matrix.example.com",
+		"work":    "https://other.example.com:8443",
+	}, hosts)
+
+	// changing the host must keep the encrypted password intact
+	require.NoError(t, setProfileHost(configDir, DefaultProfile, "https://new.example.com"))
+	data, err := ioutil.ReadFile(filepath.Join(configDir, "matrix.json"))
+	require.NoError(t, err)
+	var config MatrixConfig
+	require.NoError(t, jcrypt.Unmarshal(data, &config, &jcrypt.Options{GetKeyHandler: jcrypt.StaticKey(key)}))
+	assert.Equal(t, MatrixConfig{Host: "https://new.example.com", User: "jdoe", Pass: "secret"}, config)
+
+	require.NoError(t, removeProfile(configDir, "work"))
+	assert.Error(t, removeProfile(configDir, "work"))
+	hosts, err = listProfileHosts(configDir)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"default": "https://new.example.com"}, hosts)
+
+	assert.Error(t, setProfileHost(configDir, "../evil", "matrix.example.com"))
+	assert.Error(t, setProfileHost(configDir, "work", " "))
+}
+
+func TestCredentials(t *testing.T) {
+	configDir := t.TempDir()
+	require.NoError(t, writeMatrixConfig(filepath.Join(configDir, "matrix.json"), MatrixConfig{Host: "https://matrix.example.com", User: "jdoe", Pass: "secret"}, key))
+	require.NoError(t, writeMatrixConfig(filepath.Join(configDir, "matrix-test.json"), MatrixConfig{Host: "https://test.example.com", User: "jdoe"}, key))
+
+	hosts, err := listCredentialHosts(configDir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://matrix.example.com"}, hosts)
+
+	require.NoError(t, setCredential(configDir, DefaultPrompter, "test.example.com", "jane", "s3cret"))
+	hosts, err = listCredentialHosts(configDir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://matrix.example.com", "https://test.example.com"}, hosts)
+
+	data, err := ioutil.ReadFile(filepath.Join(configDir, "matrix-test.json"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "s3cret")
+	config, _, err := unmarshalMatrixConfig(data, DefaultPrompter)
+	require.NoError(t, err)
+	assert.Equal(t, MatrixConfig{Host: "https://test.example.com", User: "jane", Pass: "s3cret"}, config)
+
+	require.NoError(t, removeCredential(configDir, "https://MATRIX.example.com"))
+	assert.Error(t, removeCredential(configDir, "https://matrix.example.com"))
+	hosts, err = listCredentialHosts(configDir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://test.example.com"}, hosts)
+
+	assert.Error(t, setCredential(configDir, DefaultPrompter, "unknown.example.com", "jdoe", "secret"))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	projectHostFileName = ".gohome-host"
+)
+
+// findProjectHost searches dir and all its parents for a project file that overrides the Matrix host. The second return value is the file that has been found and empty if there is none.
+func findProjectHost(dir string) (string, string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", "", err
+	}
+
+	for {
+		file := filepath.Join(dir, projectHostFileName)
+		host, err := readProjectHost(file)
+		if err == nil {
+			return host, file, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", "", fmt.Errorf("invalid project file %q: %w", file, err)
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", "", nil
+		}
+		dir = parent
+	}
+}
+
+// readProjectHost returns the host in the first non-empty line of a project file. Lines starting with # are ignored.
+func readProjectHost(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		return normalizeHost(line)
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no host defined")
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindProjectHost(t *testing.T) {
+	root := t.TempDir()
+	project := filepath.Join(root, "clients", "acme")
+	sub := filepath.Join(project, "src", "app")
+	require.NoError(t, os.MkdirAll(sub, 0700))
+
+	host, file, err := findProjectHost(sub)
+	require.NoError(t, err)
+	assert.Empty(t, host)
+	assert.Empty(t, file)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(project, projectHostFileName), []byte("# matrix of acme\n\nmatrix.acme.example.com\n"), 0600))
+	host, file, err = findProjectHost(sub)
+	require.NoError(t, err)
+	assert.Equal(t, "https://matrix.acme.example.com", host)
+	assert.Equal(t, filepath.Join(project, projectHostFileName), file)
+
+	// the nearest file wins
+	require.NoError(t, ioutil.WriteFile(filepath.Join(sub, projectHostFileName), []byte("http://localhost:8080\n"), 0600))
+	host, _, err = findProjectHost(sub)
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:8080", host)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(sub, projectHostFileName), []byte("# empty\n"), 0600))
+	_, _, err = findProjectHost(sub)
+	assert.Error(t, err)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"github.com/sbreitf1/go-console"
+)
+
+var (
+	// DefaultPrompter is used to ask for missing configuration values and passphrases when no other Prompter is configured.
+	DefaultPrompter Prompter = TerminalPrompter{}
+)
+
+// Prompter asks the user for configuration values, e.g. on the terminal or in a dialog of a graphical application.
+type Prompter interface {
+	// PromptUser asks for a visible input like the host or user name. label is a short description of the requested value.
+	PromptUser(label string) (string, error)
+	// PromptPassword asks for a secret input like a password or passphrase that must not be displayed.
+	PromptPassword(label string) (string, error)
+}
+
+// TerminalPrompter asks on the terminal. Passwords are read without echo.
+type TerminalPrompter struct{}
+
+// PromptUser prints label and reads a line from stdin.
+func (TerminalPrompter) PromptUser(label string) (string, error) {
+	console.Print(label + "> ")
+	return readString()
+}
+
+// PromptPassword prints label and reads a password without echo.
+func (TerminalPrompter) PromptPassword(label string) (string, error) {
+	console.Print(label + "> ")
+	return readPassword()
+}
+
+// orDefaultPrompter returns p or DefaultPrompter if p is nil.
+func orDefaultPrompter(p Prompter) Prompter {
+	if p == nil {
+		return DefaultPrompter
+	}
+	return p
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sbreitf1/go-jcrypt"
+)
+
+// Reset is the same as Config.Reset using DefaultConfig.
+func Reset(host string) error {
+	config, err := DefaultConfig()
+	if err != nil {
+		return err
+	}
+	return config.Reset(host)
+}
+
+// ResetAll is the same as Config.ResetAll using DefaultConfig.
+func ResetAll() error {
+	config, err := DefaultConfig()
+	if err != nil {
+		return err
+	}
+	return config.ResetAll()
+}
+
+// Reset deletes all cached sessions and the stored password of all profiles for a Matrix host. Profiles are kept, so the password will be asked for on the next run.
+// Only regular files created by gohome are touched, symbolic links are skipped. Credentials in a KeyringCredentialStore are not removed.
+func (c Config) Reset(host string) error {
+	configDir, err := c.dir()
+	if err != nil {
+		return err
+	}
+	return reset(configDir, host)
+}
+
+// ResetAll deletes all profiles, cached sessions and the history from the config directory. The directory itself is removed if it is empty afterwards.
+// Only regular files created by gohome are touched, symbolic links and unknown files are skipped. Credentials in a KeyringCredentialStore are not removed.
+func (c Config) ResetAll() error {
+	configDir, err := c.dir()
+	if err != nil {
+		return err
+	}
+	return resetAll(configDir)
+}
+
+func reset(configDir, host string) error {
+	normalized, err := normalizeHost(host)
+	if err != nil {
+		return err
+	}
+
+	files, err := managedFiles(configDir)
+	if err != nil {
+		return err
+	}
+	for _, name := range files {
+		file := filepath.Join(configDir, name)
+		if _, ok := profileFromFileName(name); ok {
+			// the profile is kept, only the password is removed
+			raw, err := readRawProfile(file)
+			if err != nil {
+				return err
+			}
+			if profileHost, _ := raw["host"].(string); !sameHost(profileHost, normalized) {
+				continue
+			}
+			if _, err := removeStoredPassword(file); err != nil {
+				return err
+			}
+		} else if isSessionFileName(name) {
+			sessionHost, ok := sessionCacheHost(file)
+			if !ok || !sameHost(sessionHost, normalized) {
+				continue
+			}
+			if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	// nothing stored for the host is not an error
+	return nil
+}
+
+// sameHost returns true if host refers to the normalized host.
+func sameHost(host, normalized string) bool {
+	n, err := normalizeHost(host)
+	return err == nil && strings.EqualFold(n, normalized)
+}
+
+func resetAll(configDir string) error {
+	files, err := managedFiles(configDir)
+	if err != nil {
+		return err
+	}
+	for _, name := range files {
+		if err := os.Remove(filepath.Join(configDir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if info, err := os.Lstat(configDir); err == nil && info.IsDir() {
+		if remaining, err := ioutil.ReadDir(configDir); err == nil && len(remaining) == 0 {
+			if err := os.Remove(configDir); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// managedFiles returns the names of all regular files in configDir that have been created by gohome. A missing directory contains no files.
+func managedFiles(configDir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(configDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	files := make([]string, 0)
+	for _, info := range infos {
+		// ReadDir uses Lstat, so symbolic links are never regular files
+		if !info.Mode().IsRegular() || !isManagedFileName(info.Name()) {
+			continue
+		}
+		files = append(files, info.Name())
+	}
+	return files, nil
+}
+
+// isManagedFileName returns true for the names of profiles, session caches, the history and left-over temporary files of writePrivateFile.
+func isManagedFileName(name string) bool {
+	if _, ok := profileFromFileName(name); ok {
+		return true
+	}
+	if isSessionFileName(name) || name == historyFileName {
+		return true
+	}
+	if strings.HasPrefix(name, ".") && strings.Contains(name, ".tmp") {
+		return isManagedFileName(strings.TrimPrefix(name[:strings.Index(name, ".tmp")], "."))
+	}
+	return false
+}
+
+// isSessionFileName returns true for the names of session cache files as returned by Config.SessionCacheFile.
+func isSessionFileName(name string) bool {
+	if name == "session.json" {
+		return true
+	}
+	if !strings.HasPrefix(name, "session-") || !strings.HasSuffix(name, ".json") {
+		return false
+	}
+	return patternProfileName.MatchString(strings.TrimSuffix(strings.TrimPrefix(name, "session-"), ".json"))
+}
+
+// sessionCacheHost returns the host of a cached session regardless of its expiry. The second return value is false if the file cannot be read.
+func sessionCacheHost(file string) (string, bool) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", false
+	}
+	var session cachedSession
+	if err := jcrypt.Unmarshal(data, &session, &jcrypt.Options{GetKeyHandler: jcrypt.StaticKey(key)}); err != nil {
+		return "", false
+	}
+	return session.Host, len(session.Host) > 0
+}
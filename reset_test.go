@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sbreitf1/go-jcrypt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestSession(t *testing.T, file, host string) {
+	data, err := jcrypt.Marshal(&cachedSession{Host: host, User: "jdoe", SessionID: "abc"}, &jcrypt.Options{GetKeyHandler: jcrypt.StaticKey(key)})
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(file, data, configFilePerm))
+}
+
+func TestReset(t *testing.T) {
+	configDir := t.TempDir()
+	require.NoError(t, writeMatrixConfig(filepath.Join(configDir, "matrix.json"), MatrixConfig{Host: "https://matrix.example.com", User: "jdoe", Pass: "secret"}, key))
+	require.NoError(t, writeMatrixConfig(filepath.Join(configDir, "matrix-test.json"), MatrixConfig{Host: "https://test.example.com", User: "jdoe", Pass: "secret"}, key))
+	writeTestSession(t, filepath.Join(configDir, "session.json"), "https://matrix.example.com")
+	writeTestSession(t, filepath.Join(configDir, "session-test.json"), "https://test.example.com")
+
+	config := Config{Dir: configDir}
+	require.NoError(t, config.Reset("MATRIX.example.com"))
+
+	assert.NoFileExists(t, filepath.Join(configDir, "session.json"))
+	assert.FileExists(t, filepath.Join(configDir, "session-test.json"))
+	hosts, err := listCredentialHosts(configDir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://test.example.com"}, hosts)
+	profiles, err := listProfileHosts(configDir)
+	require.NoError(t, err)
+	assert.Len(t, profiles, 2)
+
+	// resetting again or an unknown host is not an error
+	require.NoError(t, config.Reset("matrix.example.com"))
+	require.NoError(t, config.Reset("unknown.example.com"))
+	assert.Error(t, config.Reset(" "))
+}
+
+func TestResetAll(t *testing.T) {
+	configDir := filepath.Join(t.TempDir(), "gohome")
+	require.NoError(t, os.Mkdir(configDir, configDirPerm))
+	require.NoError(t, writeMatrixConfig(filepath.Join(configDir, "matrix.json"), MatrixConfig{Host: "https://matrix.example.com", User: "jdoe", Pass: "secret"}, key))
+	require.NoError(t, writeMatrixConfig(filepath.Join(configDir, "matrix-test.json"), MatrixConfig{Host: "https://test.example.com"}, key))
+	writeTestSession(t, filepath.Join(configDir, "session-test.json"), "https://test.example.com")
+	require.NoError(t, ioutil.WriteFile(filepath.Join(configDir, historyFileName), []byte("\n"), configFilePerm))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(configDir, ".session.json.tmp123"), nil, configFilePerm))
+
+	config := Config{Dir: configDir}
+	require.NoError(t, config.ResetAll())
+	assert.NoDirExists(t, configDir)
+
+	// a missing directory is not an error
+	require.NoError(t, config.ResetAll())
+}
+
+func TestResetAllKeepsUnknownFiles(t *testing.T) {
+	configDir := t.TempDir()
+	outside := filepath.Join(t.TempDir(), "notes.json")
+	require.NoError(t, ioutil.WriteFile(outside, []byte("{}"), configFilePerm))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(configDir, "notes.txt"), nil, configFilePerm))
+	require.NoError(t, writeMatrixConfig(filepath.Join(configDir, "matrix.json"), MatrixConfig{Host: "https://matrix.example.com"}, key))
+	if err := os.Symlink(outside, filepath.Join(configDir, "matrix-link.json")); err != nil {
+		t.Skipf("symbolic links not supported: %s", err.Error())
+	}
+
+	require.NoError(t, Config{Dir: configDir}.ResetAll())
+	assert.NoFileExists(t, filepath.Join(configDir, "matrix.json"))
+	assert.FileExists(t, filepath.Join(configDir, "notes.txt"))
+	assert.FileExists(t, outside)
+	_, err := os.Lstat(filepath.Join(configDir, "matrix-link.json"))
+	assert.NoError(t, err)
+}
+
+func TestResetSkipsSymlinkedProfile(t *testing.T) {
+	configDir := t.TempDir()
+	outside := filepath.Join(t.TempDir(), "matrix.json")
+	require.NoError(t, writeMatrixConfig(outside, MatrixConfig{Host: "https://matrix.example.com", User: "jdoe", Pass: "secret"}, key))
+	before, err := ioutil.ReadFile(outside)
+	require.NoError(t, err)
+	link := filepath.Join(configDir, "matrix-link.json")
+	if err := os.Symlink(outside, link); err != nil {
+		t.Skipf("symbolic links not supported: %s", err.Error())
+	}
+	require.NoError(t, writeMatrixConfig(filepath.Join(configDir, "matrix.json"), MatrixConfig{Host: "https://matrix.example.com", User: "jdoe", Pass: "secret"}, key))
+
+	require.NoError(t, Config{Dir: configDir}.Reset("matrix.example.com"))
+
+	// the regular profile loses its password, the link and its target are untouched
+	data, err := ioutil.ReadFile(filepath.Join(configDir, "matrix.json"))
+	require.NoError(t, err)
+	_, ok := storedPasswordHost(data)
+	assert.False(t, ok)
+	info, err := os.Lstat(link)
+	require.NoError(t, err)
+	assert.True(t, info.Mode()&os.ModeSymlink != 0)
+	after, err := ioutil.ReadFile(outside)
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultRetryBaseDelay = time.Second
+	defaultRetryMaxDelay  = 30 * time.Second
+)
+
+// RetryPolicy defines how often and how fast failed requests to Matrix are repeated. Only server errors (5xx) and network errors are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts including the first one. Values below 2 disable retries.
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry and doubled for every further attempt. Defaults to 1 second if zero.
+	BaseDelay time.Duration
+	// MaxDelay limits the delay between two attempts. Defaults to 30 seconds if zero.
+	MaxDelay time.Duration
+}
+
+// delay returns the randomized delay to wait after the given failed attempt.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	baseDelay := p.BaseDelay
+	if baseDelay <= 0 {
+		baseDelay = defaultRetryBaseDelay
+	}
+	maxDelay := p.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = defaultRetryMaxDelay
+	}
+
+	delay := baseDelay
+	for i := 1; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+
+	// jitter between 50% and 100% of the delay to spread requests of many clients
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// statusError is returned when the server responds with an unexpected status code.
+type statusError struct {
+	Code     int
+	Expected int
+	// URL is the requested URL without session IDs. It is not part of the message if empty.
+	URL string
+	// Snippet is the beginning of the response body. It is not part of the message if empty.
+	Snippet string
+}
+
+func (e *statusError) Error() string {
+	msg := fmt.Sprintf("server returned code %d when %d was expected", e.Code, e.Expected)
+	if len(e.URL) > 0 {
+		msg += " for " + e.URL
+	}
+	if len(e.Snippet) > 0 {
+		msg += fmt.Sprintf(", response starts with %q", e.Snippet)
+	}
+	return msg
+}
+
+// Unwrap returns ErrAuthFailed or ErrServerUnavailable depending on the status code.
+func (e *statusError) Unwrap() error {
+	if e.Code == http.StatusUnauthorized || e.Code == http.StatusForbidden {
+		return ErrAuthFailed
+	}
+	if e.Code >= 500 {
+		return ErrServerUnavailable
+	}
+	return nil
+}
+
+// isRetryable returns true for errors that might disappear when repeating the request.
+func isRetryable(err error) bool {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// retry calls f until it succeeds, returns an error that is not retryable or the maximum number of attempts is reached.
+func (c *MatrixClient) retry(f func() error) error {
+	return retryContext(c.ctx, c.options.Retry, f)
+}
+
+// retryContext calls f according to policy until it succeeds, returns an error that is not retryable or ctx is done.
+func retryContext(ctx context.Context, policy RetryPolicy, f func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= policy.MaxAttempts || !isRetryable(err) || ctx.Err() != nil {
+			return err
+		}
+
+		select {
+		case <-time.After(policy.delay(attempt)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
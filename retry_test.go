@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+	for i := 0; i < 100; i++ {
+		assertBetween(t, 500*time.Millisecond, time.Second, policy.delay(1))
+		assertBetween(t, time.Second, 2*time.Second, policy.delay(2))
+		assertBetween(t, 2*time.Second, 4*time.Second, policy.delay(3))
+		assertBetween(t, 2500*time.Millisecond, 5*time.Second, policy.delay(10))
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	assert.True(t, isRetryable(&statusError{Code: 503, Expected: 200}))
+	assert.True(t, isRetryable(fmt.Errorf("login failed: %w", &statusError{Code: 500, Expected: 302})))
+	assert.True(t, isRetryable(&net.OpError{Op: "dial", Err: fmt.Errorf("connection refused")}))
+	assert.False(t, isRetryable(&statusError{Code: 401, Expected: 302}))
+	assert.False(t, isRetryable(&statusError{Code: 403, Expected: 200}))
+	assert.False(t, isRetryable(fmt.Errorf("unable to parse view state")))
+}
+
+func assertBetween(t *testing.T, min, max, actual time.Duration) {
+	assert.True(t, actual >= min && actual <= max, "expected %s to be between %s and %s", actual, min, max)
+}
+
+func TestStatusErrorSentinels(t *testing.T) {
+	assert.True(t, errors.Is(&statusError{Code: 401, Expected: 302}, ErrAuthFailed))
+	assert.True(t, errors.Is(fmt.Errorf("login failed: %w", &statusError{Code: 403, Expected: 302}), ErrAuthFailed))
+	assert.True(t, errors.Is(&statusError{Code: 503, Expected: 200}, ErrServerUnavailable))
+	assert.False(t, errors.Is(&statusError{Code: 404, Expected: 200}, ErrAuthFailed))
+	assert.False(t, errors.Is(&statusError{Code: 404, Expected: 200}, ErrServerUnavailable))
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sbreitf1/go-jcrypt"
+)
+
+const (
+	defaultSessionLifetime = 15 * time.Minute
+)
+
+// cachedSession contains the state of a MatrixClient that is needed to resume its session in a later run.
+type cachedSession struct {
+	Host           string `json:"host"`
+	User           string `json:"user"`
+	SessionID      string `json:"sessionId" jcrypt:"aes"`
+	RendermapToken string `json:"rendermapToken" jcrypt:"aes"`
+	// Cookies contains all cookies of the session in the format of a Cookie header.
+	Cookies         string `json:"cookies" jcrypt:"aes"`
+	MonthDataID     string `json:"monthDataId"`
+	BookingID       string `json:"bookingId"`
+	LastVisitedPage string `json:"lastVisitedPage"`
+	NextUniqueToken string `json:"nextUniqueToken"`
+	NextViewState   string `json:"nextViewState"`
+	// Expires is stored as unix time because jcrypt cannot marshal time.Time.
+	Expires int64 `json:"expires"`
+}
+
+// InvalidateSessionCache removes a cached session so the next client performs a fresh login.
+func InvalidateSessionCache(file string) error {
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// loadCachedSession returns the session stored in file. The second return value is false if there is no session for host and user, it is expired or contains no cookies.
+func loadCachedSession(file, host, user string) (cachedSession, bool) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return cachedSession{}, false
+	}
+
+	var session cachedSession
+	if err := jcrypt.Unmarshal(data, &session, &jcrypt.Options{GetKeyHandler: jcrypt.StaticKey(key)}); err != nil {
+		return cachedSession{}, false
+	}
+	if session.Host != host || session.User != user || len(session.SessionID) == 0 || len(session.Cookies) == 0 || time.Now().Unix() > session.Expires {
+		return cachedSession{}, false
+	}
+	return session, true
+}
+
+// restoreSession continues a session from a previous run.
+func (c *MatrixClient) restoreSession(session cachedSession) {
+	c.sessionID = session.SessionID
+	c.rendermapToken = session.RendermapToken
+
+	// remove all cookies of the previous session before restoring the new ones
+	expired := c.jarCookies()
+	for _, cookie := range expired {
+		cookie.Value = ""
+	}
+	c.setJarCookies(expired)
+	c.setTimeZoneCookies()
+
+	c.setJarCookies((&http.Request{Header: http.Header{"Cookie": []string{session.Cookies}}}).Cookies())
+	c.monthDataID = session.MonthDataID
+	c.bookingID = session.BookingID
+	c.lastVisitedPage = session.LastVisitedPage
+	c.nextUniqueToken = session.NextUniqueToken
+	c.nextViewState = session.NextViewState
+}
+
+// saveSession writes the current session state to the session cache file.
+func (c *MatrixClient) saveSession() error {
+	session := cachedSession{
+		Host:            c.config.Host,
+		User:            c.config.User,
+		SessionID:       c.sessionID,
+		RendermapToken:  c.rendermapToken,
+		Cookies:         joinCookies(c.jarCookies()),
+		MonthDataID:     c.monthDataID,
+		BookingID:       c.bookingID,
+		LastVisitedPage: c.lastVisitedPage,
+		NextUniqueToken: c.nextUniqueToken,
+		NextViewState:   c.nextViewState,
+		Expires:         time.Now().Add(c.sessionTimeout).Unix(),
+	}
+
+	data, err := jcrypt.Marshal(&session, &jcrypt.Options{GetKeyHandler: jcrypt.StaticKey(key)})
+	if err != nil {
+		return err
+	}
+	return writePrivateFile(c.options.SessionCacheFile, data)
+}
+
+// joinCookies returns the cookies in the format of a Cookie header.
+func joinCookies(cookies []*http.Cookie) string {
+	parts := make([]string, len(cookies))
+	for i, cookie := range cookies {
+		parts[i] = cookie.Name + "=" + cookie.Value
+	}
+	return strings.Join(parts, "; ")
+}
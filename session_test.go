@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sbreitf1/go-jcrypt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionCache(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "session.json")
+	client := &MatrixClient{
+		config:          MatrixConfig{Host: "https://matrix.example.com", User: "jdoe", Pass: "secret"},
+		options:         FetchOptions{SessionCacheFile: file},
+		sessionID:       "0123456789ABCDEF",
+		rendermapToken:  "token",
+		monthDataID:     "42",
+		bookingID:       "17",
+		lastVisitedPage: "/bookings.jsf",
+		nextUniqueToken: "a1b2c3",
+		nextViewState:   "-123:456",
+		sessionTimeout:  time.Minute,
+		httpClient:      &http.Client{Jar: newCookieJar()},
+	}
+	client.setJarCookies([]*http.Cookie{{Name: matrixSessionCookieName, Value: "0123456789ABCDEF"}, {Name: mockAffinityCookie, Value: "node1"}})
+	client.setTimeZoneCookies()
+	require.NoError(t, client.saveSession())
+
+	data, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "0123456789ABCDEF")
+	assert.NotContains(t, string(data), "secret")
+
+	session, ok := loadCachedSession(file, "https://matrix.example.com", "jdoe")
+	require.True(t, ok)
+	restored := &MatrixClient{config: client.config, httpClient: &http.Client{Jar: newCookieJar()}}
+	restored.restoreSession(session)
+	assert.ElementsMatch(t, client.jarCookies(), restored.jarCookies())
+	assert.Contains(t, session.Cookies, "timezonename=Europe/Berlin")
+	assert.Equal(t, client.sessionID, restored.sessionID)
+	assert.Equal(t, client.rendermapToken, restored.rendermapToken)
+	assert.Equal(t, client.monthDataID, restored.monthDataID)
+	assert.Equal(t, client.bookingID, restored.bookingID)
+	assert.Equal(t, client.lastVisitedPage, restored.lastVisitedPage)
+	assert.Equal(t, client.nextUniqueToken, restored.nextUniqueToken)
+	assert.Equal(t, client.nextViewState, restored.nextViewState)
+
+	// sessions of other accounts are ignored
+	_, ok = loadCachedSession(file, "https://matrix.example.com", "jane")
+	assert.False(t, ok)
+	_, ok = loadCachedSession(file, "https://other.example.com", "jdoe")
+	assert.False(t, ok)
+}
+
+func TestSessionCacheWithoutCookies(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "session.json")
+	session := cachedSession{Host: "https://matrix.example.com", User: "jdoe", SessionID: "0123456789ABCDEF", Expires: time.Now().Add(time.Minute).Unix()}
+	data, err := jcrypt.Marshal(&session, &jcrypt.Options{GetKeyHandler: jcrypt.StaticKey(key)})
+	require.NoError(t, err)
+	require.NoError(t, writePrivateFile(file, data))
+
+	_, ok := loadCachedSession(file, "https://matrix.example.com", "jdoe")
+	assert.False(t, ok)
+}
+
+func TestSessionCacheExpired(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "session.json")
+	session := cachedSession{Host: "https://matrix.example.com", User: "jdoe", SessionID: "0123456789ABCDEF", Expires: time.Now().Add(-time.Minute).Unix()}
+	data, err := jcrypt.Marshal(&session, &jcrypt.Options{GetKeyHandler: jcrypt.StaticKey(key)})
+	require.NoError(t, err)
+	require.NoError(t, writePrivateFile(file, data))
+
+	_, ok := loadCachedSession(file, "https://matrix.example.com", "jdoe")
+	assert.False(t, ok)
+
+	require.NoError(t, InvalidateSessionCache(file))
+	assert.NoFileExists(t, file)
+	require.NoError(t, InvalidateSessionCache(file))
+}
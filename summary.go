@@ -0,0 +1,131 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	// DefaultMinRest is the minimum rest period between two workdays required by the German working hours act.
+	DefaultMinRest = 11 * time.Hour
+)
+
+// DaySummary contains the key figures of a single calendar day.
+type DaySummary struct {
+	// Date is the start of the day in the time zone of the entries.
+	Date time.Time
+	// FirstCome is the start of the first work session of the day.
+	FirstCome time.Time
+	// LastLeave is the end of the last work session of the day. It is zero if the day is still in progress.
+	LastLeave time.Time
+	// Worked is the sum of all work sessions of the day. An open session is measured until now.
+	Worked time.Duration
+	// Breaks is the sum of all gaps between work sessions of the day.
+	Breaks time.Duration
+	// Sessions is the number of work sessions of the day.
+	Sessions int
+}
+
+// BuildDaySummaries returns a summary for every calendar day with work sessions in chronological order. Sessions crossing midnight are split, so FirstCome or LastLeave are midnight in that case.
+func BuildDaySummaries(entries []Entry) ([]DaySummary, error) {
+	return buildDaySummaries(entries, time.Now())
+}
+
+func buildDaySummaries(entries []Entry, now time.Time) ([]DaySummary, error) {
+	sessions, err := workSessions(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([]DaySummary, 0)
+	var lastEnd time.Time
+	for _, s := range sessions {
+		end := s.End
+		if s.Open {
+			end = now
+			if end.Before(s.Start) {
+				end = s.Start
+			}
+		}
+
+		start := s.Start
+		for {
+			day := startOfDay(start)
+			partEnd := day.AddDate(0, 0, 1)
+			if end.Before(partEnd) {
+				partEnd = end
+			}
+
+			if len(summaries) == 0 || !summaries[len(summaries)-1].Date.Equal(day) {
+				summaries = append(summaries, DaySummary{Date: day, FirstCome: start})
+			} else {
+				summaries[len(summaries)-1].Breaks += start.Sub(lastEnd)
+			}
+			summary := &summaries[len(summaries)-1]
+			summary.Worked += partEnd.Sub(start)
+			summary.Sessions++
+			if s.Open && partEnd.Equal(end) {
+				summary.LastLeave = time.Time{}
+			} else {
+				summary.LastLeave = partEnd
+			}
+			lastEnd = partEnd
+
+			if !partEnd.Before(end) {
+				break
+			}
+			start = partEnd
+		}
+	}
+	return summaries, nil
+}
+
+// RestViolation describes a rest period between two workdays that is shorter than required.
+type RestViolation struct {
+	// LastLeave is the end of the last work session of the earlier workday.
+	LastLeave time.Time
+	// NextCome is the start of the first work session of the following workday.
+	NextCome time.Time
+	// Rest is the duration between LastLeave and NextCome.
+	Rest time.Duration
+}
+
+// CheckRestPeriods returns all rest periods between two workdays that are shorter than minRest in chronological order, e.g. DefaultMinRest of 11 hours.
+// A workday ends with the last session that started on the same calendar day, so night shifts across midnight are not split.
+func CheckRestPeriods(entries []Entry, minRest time.Duration) ([]RestViolation, error) {
+	sessions, err := workSessions(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	violations := make([]RestViolation, 0)
+	for i := 1; i < len(sessions); i++ {
+		prev, next := sessions[i-1], sessions[i]
+		if !startOfDay(next.Start).After(startOfDay(prev.Start)) {
+			// break within the same workday
+			continue
+		}
+		if rest := next.Start.Sub(prev.End); rest < minRest {
+			violations = append(violations, RestViolation{LastLeave: prev.End, NextCome: next.Start, Rest: rest})
+		}
+	}
+	return violations, nil
+}
+
+// GroupByDay returns the entries of every calendar day in chronological order. Days are identified by midnight in the time zone of the entries, so entries of one day in different time zones end up in different groups.
+func GroupByDay(entries []Entry) map[time.Time][]Entry {
+	days := make(map[time.Time][]Entry)
+	for _, entry := range entries {
+		day := startOfDay(entry.Time)
+		days[day] = append(days[day], entry)
+	}
+	for _, dayEntries := range days {
+		sort.SliceStable(dayEntries, func(i, j int) bool { return dayEntries[i].Time.Before(dayEntries[j].Time) })
+	}
+	return days
+}
+
+// startOfDay returns midnight of the day of t in the location of t.
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
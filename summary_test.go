@@ -0,0 +1,113 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildDaySummaries(t *testing.T) {
+	day := func(d, hours, minutes int) time.Time {
+		return time.Date(2019, time.November, d, hours, minutes, 0, 0, time.UTC)
+	}
+	entries := []Entry{
+		// multiple sessions with a pause
+		{Type: EntryTypeCome, Time: day(4, 8, 0)},
+		{Type: EntryTypeLeave, Time: day(4, 12, 0)},
+		{Type: EntryTypeCome, Time: day(4, 12, 30)},
+		{Type: EntryTypePauseStart, Time: day(4, 14, 0)},
+		{Type: EntryTypePauseEnd, Time: day(4, 14, 15)},
+		{Type: EntryTypeLeave, Time: day(4, 17, 0)},
+		// night shift across midnight
+		{Type: EntryTypeCome, Time: day(5, 22, 0)},
+		{Type: EntryTypeLeave, Time: day(6, 2, 0)},
+		// day in progress
+		{Type: EntryTypeCome, Time: day(7, 9, 0)},
+	}
+
+	summaries, err := buildDaySummaries(entries, day(7, 11, 30))
+	require.NoError(t, err)
+	assert.Equal(t, []DaySummary{
+		{Date: day(4, 0, 0), FirstCome: day(4, 8, 0), LastLeave: day(4, 17, 0), Worked: 8*time.Hour + 15*time.Minute, Breaks: 45 * time.Minute, Sessions: 3},
+		{Date: day(5, 0, 0), FirstCome: day(5, 22, 0), LastLeave: day(6, 0, 0), Worked: 2 * time.Hour, Sessions: 1},
+		{Date: day(6, 0, 0), FirstCome: day(6, 0, 0), LastLeave: day(6, 2, 0), Worked: 2 * time.Hour, Sessions: 1},
+		{Date: day(7, 0, 0), FirstCome: day(7, 9, 0), Worked: 2*time.Hour + 30*time.Minute, Sessions: 1},
+	}, summaries)
+}
+
+func TestBuildDaySummariesEmpty(t *testing.T) {
+	summaries, err := BuildDaySummaries(nil)
+	require.NoError(t, err)
+	assert.Empty(t, summaries)
+}
+
+func TestCheckRestPeriods(t *testing.T) {
+	day := func(d, hours, minutes int) time.Time {
+		return time.Date(2019, time.November, d, hours, minutes, 0, 0, time.UTC)
+	}
+	entries := []Entry{
+		{Type: EntryTypeCome, Time: day(4, 8, 0)},
+		{Type: EntryTypeLeave, Time: day(4, 12, 0)},
+		{Type: EntryTypeCome, Time: day(4, 12, 30)},
+		{Type: EntryTypeLeave, Time: day(4, 22, 0)},
+		// only 8 hours rest
+		{Type: EntryTypeCome, Time: day(5, 6, 0)},
+		{Type: EntryTypeLeave, Time: day(5, 14, 0)},
+		// night shift across midnight with 8 hours rest
+		{Type: EntryTypeCome, Time: day(5, 22, 0)},
+		{Type: EntryTypeLeave, Time: day(6, 6, 0)},
+		// 12 hours rest
+		{Type: EntryTypeCome, Time: day(6, 18, 0)},
+	}
+
+	violations, err := CheckRestPeriods(entries, DefaultMinRest)
+	require.NoError(t, err)
+	assert.Equal(t, []RestViolation{
+		{LastLeave: day(4, 22, 0), NextCome: day(5, 6, 0), Rest: 8 * time.Hour},
+	}, violations)
+
+	// the night shift starts on the same day as the previous session
+	violations, err = CheckRestPeriods(entries[4:], DefaultMinRest)
+	require.NoError(t, err)
+	assert.Empty(t, violations)
+
+	violations, err = CheckRestPeriods(entries, 13*time.Hour)
+	require.NoError(t, err)
+	assert.Len(t, violations, 2)
+
+	violations, err = CheckRestPeriods(nil, DefaultMinRest)
+	require.NoError(t, err)
+	assert.Equal(t, []RestViolation{}, violations)
+
+	_, err = CheckRestPeriods([]Entry{{Type: EntryTypeLeave, Time: day(4, 8, 0)}}, DefaultMinRest)
+	assert.Error(t, err)
+}
+
+func TestGroupByDay(t *testing.T) {
+	day := func(d, hours, minutes int) time.Time {
+		return time.Date(2019, time.November, d, hours, minutes, 0, 0, time.UTC)
+	}
+	entries := []Entry{
+		{Type: EntryTypeCome, Time: day(5, 8, 0)},
+		{Type: EntryTypeLeave, Time: day(4, 16, 0)},
+		{Type: EntryTypeCome, Time: day(4, 8, 0)},
+		{Type: EntryTypeLeave, Time: day(5, 0, 0)},
+		{Type: EntryTypeCome, Time: day(4, 22, 0)},
+	}
+
+	assert.Equal(t, map[time.Time][]Entry{
+		day(4, 0, 0): {
+			{Type: EntryTypeCome, Time: day(4, 8, 0)},
+			{Type: EntryTypeLeave, Time: day(4, 16, 0)},
+			{Type: EntryTypeCome, Time: day(4, 22, 0)},
+		},
+		day(5, 0, 0): {
+			{Type: EntryTypeLeave, Time: day(5, 0, 0)},
+			{Type: EntryTypeCome, Time: day(5, 8, 0)},
+		},
+	}, GroupByDay(entries))
+
+	assert.Empty(t, GroupByDay(nil))
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	redacted = "REDACTED"
+)
+
+var (
+	patternSecretFormField = regexp.MustCompile(`(?i)((?:^|&)[^=&]*pass[^=&]*=)[^&]*`)
+	patternSessionIDParam  = regexp.MustCompile(`(?i)(jsessionid=)[^&;?#"'\s]*`)
+)
+
+// traceTransport writes a transcript of all requests and responses to a writer with credentials and cookies redacted.
+type traceTransport struct {
+	next http.RoundTripper
+	w    io.Writer
+}
+
+// newTraceTransport returns next wrapped in a traceTransport. http.DefaultTransport is used if next is nil.
+func newTraceTransport(next http.RoundTripper, w io.Writer) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &traceTransport{next: next, w: w}
+}
+
+// RoundTrip performs the request and writes request and response to the trace in a single write, so concurrent clients do not interleave.
+func (t *traceTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "> %s %s\n", request.Method, redactURL(request.URL.String()))
+	writeTraceHeader(&buf, ">", request.Header)
+	if request.Body != nil && request.GetBody != nil {
+		// the body is read from a copy to keep the original untouched
+		body, err := request.GetBody()
+		if err == nil {
+			data, _ := io.ReadAll(body)
+			body.Close()
+			fmt.Fprintf(&buf, ">\n> %s\n", redactFormBody(string(data)))
+		}
+	}
+
+	response, err := t.next.RoundTrip(request)
+	if err != nil {
+		fmt.Fprintf(&buf, "< error: %s\n\n", err.Error())
+		t.w.Write(buf.Bytes())
+		return nil, err
+	}
+
+	fmt.Fprintf(&buf, "< %s %s\n", response.Proto, response.Status)
+	writeTraceHeader(&buf, "<", response.Header)
+	data, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	response.Body = io.NopCloser(bytes.NewReader(data))
+	if err != nil {
+		fmt.Fprintf(&buf, "< error reading body: %s\n\n", err.Error())
+		t.w.Write(buf.Bytes())
+		return nil, err
+	}
+	if len(data) > 0 {
+		fmt.Fprintf(&buf, "<\n%s\n", patternSessionIDParam.ReplaceAllString(string(data), "${1}"+redacted))
+	}
+	buf.WriteString("\n")
+	t.w.Write(buf.Bytes())
+	return response, nil
+}
+
+// writeTraceHeader writes all header fields in alphabetical order. Values of cookies and authorization headers are redacted.
+func writeTraceHeader(w io.Writer, prefix string, header http.Header) {
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		for _, value := range header[key] {
+			switch http.CanonicalHeaderKey(key) {
+			case "Cookie":
+				value = redactCookies(value)
+			case "Set-Cookie":
+				value = redactCookies(strings.SplitN(value, ";", 2)[0])
+			case "Authorization", "Proxy-Authorization":
+				value = redacted
+			case "Location":
+				value = redactURL(value)
+			}
+			fmt.Fprintf(w, "%s %s: %s\n", prefix, key, value)
+		}
+	}
+}
+
+// redactCookies replaces the values of all cookies in a Cookie header while keeping the names.
+func redactCookies(value string) string {
+	parts := strings.Split(value, ";")
+	for i, part := range parts {
+		name := strings.SplitN(strings.TrimSpace(part), "=", 2)[0]
+		parts[i] = name + "=" + redacted
+	}
+	return strings.Join(parts, "; ")
+}
+
+// redactURL replaces session IDs that are part of a URL.
+func redactURL(str string) string {
+	return patternSessionIDParam.ReplaceAllString(str, "${1}"+redacted)
+}
+
+// redactFormBody replaces the values of all form fields with a name containing "pass".
+func redactFormBody(body string) string {
+	return patternSecretFormField.ReplaceAllString(body, "${1}"+redacted)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactFormBody(t *testing.T) {
+	assert.Equal(t, "userid=jdoe&password=REDACTED&systemLevel=false", redactFormBody("userid=jdoe&password=s%26cret&systemLevel=false"))
+	assert.Equal(t, "Password=REDACTED", redactFormBody("Password=secret"))
+	assert.Equal(t, "uniqueToken=a1b2", redactFormBody("uniqueToken=a1b2"))
+}
+
+func TestRedactCookies(t *testing.T) {
+	assert.Equal(t, "JSESSIONID=REDACTED; ROUTEID=REDACTED", redactCookies("JSESSIONID=SESSION0001; ROUTEID=.node1"))
+	assert.Equal(t, "/matrix/page.jsf;jsessionid=REDACTED?a=1", redactURL("/matrix/page.jsf;jsessionid=ABC123?a=1"))
+}
+
+func TestTrace(t *testing.T) {
+	mock := newMockMatrix(t)
+
+	var trace bytes.Buffer
+	entries, _, err := FetchMatrixEntriesContext(context.Background(), mock.Config(), FetchOptions{Trace: &trace})
+	require.NoError(t, err)
+	assert.Len(t, entries, 3)
+
+	transcript := trace.String()
+	assert.Contains(t, transcript, "> POST "+mock.URL+mockMatrixPrefix+"/login.jspx")
+	assert.Contains(t, transcript, "< HTTP/1.1 302 Found")
+	assert.Contains(t, transcript, "userid=jdoe&password=REDACTED")
+	assert.Contains(t, transcript, "JSESSIONID=REDACTED")
+	assert.Contains(t, transcript, "logTypeOfBookingTable")
+	assert.NotContains(t, transcript, mock.Pass)
+	assert.NotContains(t, transcript, "SESSION0001")
+	assert.NotContains(t, transcript, ".node1")
+}
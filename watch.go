@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// watchMaxBackoff limits the delay after failed fetches to a multiple of the interval.
+	watchMaxBackoff = 8
+)
+
+// Watch fetches today's entries every interval and calls onChange with all entries after the first fetch and whenever they have changed since the previous fetch. See WatchWithOptions for details.
+func Watch(ctx context.Context, config MatrixConfig, interval time.Duration, onChange func([]Entry)) error {
+	return WatchWithOptions(ctx, config, FetchOptions{}, interval, onChange)
+}
+
+// WatchWithOptions is like Watch but uses options for the connection. The session is reused for all fetches and a new login is performed when it has expired. Rows that cannot be parsed are skipped and reported to options.Logger.
+// After failed fetches the delay is doubled up to 8 times the interval. Watch only returns ctx.Err() when ctx is cancelled or an error wrapping ErrAuthFailed, because repeating a login with wrong credentials might lock the account.
+func WatchWithOptions(ctx context.Context, config MatrixConfig, options FetchOptions, interval time.Duration, onChange func([]Entry)) error {
+	if interval <= 0 {
+		return fmt.Errorf("watch interval must be positive")
+	}
+	options = options.withDefaults()
+
+	w := &watcher{ctx: ctx, config: config, options: options}
+	defer w.close()
+
+	var previous []Entry
+	fetched := false
+	failures := 0
+	for {
+		entries, err := w.fetch()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			if errors.Is(err, ErrAuthFailed) {
+				return err
+			}
+			failures++
+			options.Logger.Printf("watch: fetch failed %d times: %s", failures, err.Error())
+		} else {
+			if !fetched || entriesChanged(previous, entries) {
+				onChange(entries)
+			}
+			previous = entries
+			fetched = true
+			failures = 0
+		}
+
+		timer := time.NewTimer(watchDelay(interval, failures))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// watcher keeps a MatrixClient between the fetches of Watch.
+type watcher struct {
+	ctx     context.Context
+	config  MatrixConfig
+	options FetchOptions
+	client  *MatrixClient
+}
+
+// fetch returns today's entries and logs in again if the session has expired.
+func (w *watcher) fetch() ([]Entry, error) {
+	for attempt := 1; ; attempt++ {
+		if w.client == nil {
+			client, err := NewMatrixClientContext(w.ctx, w.config, w.options)
+			if err != nil {
+				return nil, err
+			}
+			w.client = client
+		}
+
+		entries, err := w.client.GetEntries()
+		if entries != nil {
+			// entries are also returned together with RowErrors for skipped rows
+			if err != nil {
+				w.options.Logger.Printf("watch: skipped rows: %s", err.Error())
+			}
+			return entries, nil
+		}
+
+		w.close()
+		if !errors.Is(err, ErrSessionExpired) || attempt >= maxLoginAttempts {
+			return nil, err
+		}
+		if len(w.options.SessionCacheFile) > 0 {
+			InvalidateSessionCache(w.options.SessionCacheFile)
+		}
+	}
+}
+
+func (w *watcher) close() {
+	if w.client != nil {
+		w.client.Close()
+		w.client = nil
+	}
+}
+
+// watchDelay returns the delay before the next fetch after the given number of consecutive failures.
+func watchDelay(interval time.Duration, failures int) time.Duration {
+	delay := interval
+	for i := 0; i < failures && delay < watchMaxBackoff*interval; i++ {
+		delay *= 2
+	}
+	if delay > watchMaxBackoff*interval {
+		delay = watchMaxBackoff * interval
+	}
+	return delay
+}
+
+// entriesChanged returns true if an entry has been added or removed.
+func entriesChanged(oldEntries, newEntries []Entry) bool {
+	return len(DiffEntries(oldEntries, newEntries)) > 0 || len(DiffEntries(newEntries, oldEntries)) > 0
+}
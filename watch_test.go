@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatch(t *testing.T) {
+	mock := newMockMatrix(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes := make(chan []Entry, 10)
+	result := make(chan error, 1)
+	go func() {
+		result <- Watch(ctx, mock.Config(), 10*time.Millisecond, func(entries []Entry) { changes <- entries })
+	}()
+
+	entries := receiveEntries(t, changes)
+	assert.Len(t, entries, 3)
+
+	// a new login is performed for the expired session
+	mock.ExpireSessions()
+	mock.SetEntriesFile("entries_reformatted.html")
+	entries = receiveEntries(t, changes)
+	assert.Equal(t, []Entry{
+		{Type: EntryTypeCome, Time: today(8, 12)},
+		{Type: EntryTypeLeave, Time: today(16, 47)},
+	}, entries)
+
+	// unchanged entries are not reported again
+	requests := mock.Requests()
+	for mock.Requests() < requests+6 {
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.Len(t, changes, 0)
+
+	cancel()
+	select {
+	case err := <-result:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch not stopped")
+	}
+}
+
+func TestWatchServerErrors(t *testing.T) {
+	mock := newMockMatrix(t)
+	mock.FailNext(2, http.StatusServiceUnavailable)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes := make(chan []Entry, 10)
+	go Watch(ctx, mock.Config(), 10*time.Millisecond, func(entries []Entry) { changes <- entries })
+	assert.Len(t, receiveEntries(t, changes), 3)
+}
+
+func TestWatchAuthFailed(t *testing.T) {
+	mock := newMockMatrix(t)
+	config := mock.Config()
+	config.Pass = "wrong"
+
+	err := Watch(context.Background(), config, 10*time.Millisecond, func([]Entry) { t.Error("unexpected change") })
+	assert.True(t, errors.Is(err, ErrAuthFailed))
+
+	assert.Error(t, Watch(context.Background(), config, 0, func([]Entry) {}))
+}
+
+// recordingLogger keeps all messages for inspection by tests.
+type recordingLogger struct {
+	mutex    sync.Mutex
+	messages []string
+}
+
+func (l *recordingLogger) Printf(format string, v ...interface{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.messages = append(l.messages, fmt.Sprintf(format, v...))
+}
+
+func (l *recordingLogger) Messages() []string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return append([]string(nil), l.messages...)
+}
+
+func TestWatchSkippedRows(t *testing.T) {
+	mock := newMockMatrix(t)
+	mock.SetEntriesFile("entries_invalid_row.html")
+	logger := &recordingLogger{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes := make(chan []Entry, 10)
+	go WatchWithOptions(ctx, mock.Config(), FetchOptions{Logger: logger}, 10*time.Millisecond, func(entries []Entry) { changes <- entries })
+	assert.Len(t, receiveEntries(t, changes), 2)
+
+	var skipped []string
+	for _, message := range logger.Messages() {
+		if strings.HasPrefix(message, "watch: skipped rows") {
+			skipped = append(skipped, message)
+		}
+	}
+	require.NotEmpty(t, skipped)
+	assert.Contains(t, skipped[0], "booking row")
+}
+
+type watchDelayCase struct {
+	Failures int
+	Delay    time.Duration
+}
+
+func TestWatchDelay(t *testing.T) {
+	testCases := []watchDelayCase{
+		{Failures: 0, Delay: time.Minute},
+		{Failures: 1, Delay: 2 * time.Minute},
+		{Failures: 3, Delay: 8 * time.Minute},
+		{Failures: 10, Delay: 8 * time.Minute},
+	}
+
+	for _, c := range testCases {
+		t.Run(fmt.Sprintf("%d failures", c.Failures), func(t *testing.T) {
+			assert.Equal(t, c.Delay, watchDelay(time.Minute, c.Failures))
+		})
+	}
+}
+
+func receiveEntries(t *testing.T, changes <-chan []Entry) []Entry {
+	select {
+	case entries := <-changes:
+		return entries
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "no change reported")
+		return nil
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultWebhookTimeout = 10 * time.Second
+	defaultWebhookRetries = 3
+)
+
+// WebhookOptions configures how notifications are sent to a webhook.
+type WebhookOptions struct {
+	// Timeout limits the duration of a single request. Defaults to 10 seconds if zero.
+	Timeout time.Duration
+	// Retry defines how often failed requests are repeated. Defaults to 3 attempts if MaxAttempts is zero.
+	Retry RetryPolicy
+	// HTTPClient is used for all requests if set. Timeout is still applied to every request.
+	HTTPClient *http.Client
+}
+
+// withDefaults returns a copy of the options with default values for all unset fields.
+func (o WebhookOptions) withDefaults() WebhookOptions {
+	if o.Timeout <= 0 {
+		o.Timeout = defaultWebhookTimeout
+	}
+	if o.Retry.MaxAttempts == 0 {
+		o.Retry.MaxAttempts = defaultWebhookRetries
+	}
+	if o.HTTPClient == nil {
+		o.HTTPClient = &http.Client{}
+	}
+	return o
+}
+
+// NotifyOnChange sends a POST request to webhookURL for every come and leave entry in current that is not contained in previous. See NotifyOnChangeContext for details.
+func NotifyOnChange(webhookURL string, previous, current []Entry) error {
+	return NotifyOnChangeContext(context.Background(), webhookURL, previous, current, WebhookOptions{})
+}
+
+// NotifyOnChangeContext sends a POST request to webhookURL for every come and leave entry in current that is not contained in previous.
+// The body is the JSON representation of the entry like {"time":"2019-11-01T08:00:00+01:00","type":"come"}. Entries are sent in chronological order and the first failed notification aborts.
+func NotifyOnChangeContext(ctx context.Context, webhookURL string, previous, current []Entry, options WebhookOptions) error {
+	options = options.withDefaults()
+
+	for _, entry := range DiffEntries(previous, current) {
+		if entry.Type != EntryTypeCome && entry.Type != EntryTypeLeave {
+			continue
+		}
+		payload, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		if err := retryContext(ctx, options.Retry, func() error {
+			return postWebhook(ctx, options, webhookURL, payload)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// postWebhook sends a single notification and returns a statusError for all responses other than 2xx.
+func postWebhook(ctx context.Context, options WebhookOptions, webhookURL string, payload []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, options.Timeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "gohome/"+Version)
+
+	response, err := options.HTTPClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, response.Body)
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return &statusError{Code: response.StatusCode, Expected: http.StatusOK}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type webhookServer struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	failNext int
+	requests int
+	received []Entry
+}
+
+func newWebhookServer(t *testing.T, failNext int) *webhookServer {
+	s := &webhookServer{failNext: failNext}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		s.requests++
+		if s.failNext > 0 {
+			s.failNext--
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		var entry Entry
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&entry))
+		s.received = append(s.received, entry)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestNotifyOnChange(t *testing.T) {
+	server := newWebhookServer(t, 0)
+
+	previous := []Entry{come(8, 0)}
+	current := []Entry{come(8, 0), trip(10, 0), leave(12, 0), come(12, 30)}
+	require.NoError(t, NotifyOnChange(server.URL, previous, current))
+
+	require.Len(t, server.received, 2)
+	assert.True(t, leave(12, 0).Time.Equal(server.received[0].Time))
+	assert.Equal(t, EntryTypeLeave, server.received[0].Type)
+	assert.True(t, come(12, 30).Time.Equal(server.received[1].Time))
+	assert.Equal(t, EntryTypeCome, server.received[1].Type)
+}
+
+func TestNotifyOnChangeRetry(t *testing.T) {
+	server := newWebhookServer(t, 2)
+	options := WebhookOptions{Retry: RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}}
+	require.NoError(t, NotifyOnChangeContext(context.Background(), server.URL, nil, []Entry{come(8, 0)}, options))
+	assert.Equal(t, 3, server.requests)
+	assert.Len(t, server.received, 1)
+
+	server = newWebhookServer(t, 5)
+	err := NotifyOnChangeContext(context.Background(), server.URL, nil, []Entry{come(8, 0)}, options)
+	assert.True(t, errors.Is(err, ErrServerUnavailable))
+	assert.Equal(t, 3, server.requests)
+}
+
+func TestNotifyOnChangeTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	options := WebhookOptions{Timeout: 50 * time.Millisecond, Retry: RetryPolicy{MaxAttempts: 1}}
+	start := time.Now()
+	err := NotifyOnChangeContext(context.Background(), server.URL, nil, []Entry{come(8, 0)}, options)
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) < 2*time.Second)
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"sort"
 	"time"
 )
 
@@ -12,11 +14,17 @@ const (
 	EntryTypeLeave EntryType = "leave"
 	// EntryTypeTrip denotes an entry for a short business trip.
 	EntryTypeTrip EntryType = "trip"
+	// EntryTypePauseStart denotes an entry when starting a break without leaving the company.
+	EntryTypePauseStart EntryType = "pause-start"
+	// EntryTypePauseEnd denotes an entry when ending a break without leaving the company.
+	EntryTypePauseEnd EntryType = "pause-end"
 )
 
 var (
 	// ErrNoEntries is returned when no entries are available for computation.
 	ErrNoEntries = fmt.Errorf("no entries")
+	// ErrNotClockedIn is returned when a computation requires an open work session.
+	ErrNotClockedIn = fmt.Errorf("not clocked in")
 	// ErrMaxTimeReached is returned when a solution would exceed the maximum working time.
 	ErrMaxTimeReached = fmt.Errorf("a maximum working time of 10 hours per day is allowed")
 	// TODO: implement this:
@@ -24,10 +32,138 @@ var (
 	//ErrOutOfBusinessHours = fmt.Errorf("business hours are from 6:30 to 21:00")
 )
 
+// EntryTimeFormat is the time format used for JSON representations of entries.
+const EntryTimeFormat = "2006-01-02T15:04:05-07:00"
+
 // Entry describes an entry for coming or leaving to a given time.
 type Entry struct {
+	Type EntryType `json:"type"`
+	Time time.Time `json:"time"`
+}
+
+type jsonEntry struct {
+	Time string    `json:"time"`
+	Type EntryType `json:"type"`
+}
+
+// MarshalJSON returns the JSON representation of an entry with time formatted as EntryTimeFormat.
+func (e Entry) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonEntry{Time: e.Time.Format(EntryTimeFormat), Type: e.Type})
+}
+
+// UnmarshalJSON parses the JSON representation of an entry as written by MarshalJSON.
+func (e *Entry) UnmarshalJSON(data []byte) error {
+	var raw jsonEntry
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	t, err := time.Parse(EntryTimeFormat, raw.Time)
+	if err != nil {
+		return err
+	}
+	e.Time = t
+	e.Type = raw.Type
+	return nil
+}
+
+// entryKey identifies an entry by time and type independent of the time zone.
+type entryKey struct {
+	Unix int64
 	Type EntryType
-	Time time.Time
+}
+
+func newEntryKey(entry Entry) entryKey {
+	return entryKey{Unix: entry.Time.Unix(), Type: entry.Type}
+}
+
+// DiffEntries returns all entries of newEntries that are not contained in oldEntries in chronological order. Entries are compared by time and type, so the order of both lists does not matter.
+func DiffEntries(oldEntries, newEntries []Entry) []Entry {
+	known := make(map[entryKey]bool, len(oldEntries))
+	for _, entry := range oldEntries {
+		known[newEntryKey(entry)] = true
+	}
+
+	added := make([]Entry, 0)
+	for _, entry := range newEntries {
+		k := newEntryKey(entry)
+		if known[k] {
+			continue
+		}
+		known[k] = true
+		added = append(added, entry)
+	}
+	sort.SliceStable(added, func(i, j int) bool { return added[i].Time.Before(added[j].Time) })
+	return added
+}
+
+// MergeEntries returns the union of a and b in chronological order, e.g. to combine the local history with a fresh fetch. Entries are compared by time and type and only the first occurrence is kept, preferring a over b.
+// Entries with the same time keep their order with all entries of a before those of b. The result is never nil.
+func MergeEntries(a, b []Entry) []Entry {
+	known := make(map[entryKey]bool, len(a)+len(b))
+	merged := make([]Entry, 0, len(a)+len(b))
+	for _, entries := range [][]Entry{a, b} {
+		for _, entry := range entries {
+			k := newEntryKey(entry)
+			if known[k] {
+				continue
+			}
+			known[k] = true
+			merged = append(merged, entry)
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].Time.Before(merged[j].Time) })
+	return merged
+}
+
+// DedupEntries removes entries that repeat the previous entry with identical time and type, e.g. caused by scanning a badge twice. Entries are never removed by fetching functions, so this step has to be applied explicitly.
+func DedupEntries(entries []Entry) []Entry {
+	deduped := make([]Entry, 0, len(entries))
+	for i, entry := range entries {
+		if i > 0 && newEntryKey(entry) == newEntryKey(entries[i-1]) {
+			continue
+		}
+		deduped = append(deduped, entry)
+	}
+	return deduped
+}
+
+// LastN returns the n most recent entries with the latest entry first. Entries with the same time keep their relative order. The input is not modified and the result is never nil.
+func LastN(entries []Entry, n int) []Entry {
+	sorted := make([]Entry, len(entries))
+	copy(sorted, entries)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Time.After(sorted[j].Time) })
+	if n < 0 {
+		n = 0
+	}
+	if n > len(sorted) {
+		n = len(sorted)
+	}
+	return sorted[:n]
+}
+
+// FilterByType returns all entries of the given type. The result is never nil.
+func FilterByType(entries []Entry, entryType EntryType) []Entry {
+	filtered := make([]Entry, 0)
+	for _, entry := range entries {
+		if entry.Type == entryType {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered
+}
+
+// FilterByDay returns all entries on the calendar day of day in its time zone. The result is never nil.
+func FilterByDay(entries []Entry, day time.Time) []Entry {
+	start := startOfDay(day)
+	end := start.AddDate(0, 0, 1)
+
+	filtered := make([]Entry, 0)
+	for _, entry := range entries {
+		if !entry.Time.Before(start) && entry.Time.Before(end) {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered
 }
 
 // EntryType denotes whether an entry is for coming or leaving the company.
@@ -35,6 +171,11 @@ type EntryType string
 
 // ComputeWorkTime returns the actual work time, start time and taken break from a set of entries.
 func ComputeWorkTime(entries []Entry) (time.Duration, time.Time, time.Duration, error) {
+	return computeWorkTime(entries, time.Now())
+}
+
+// computeWorkTime is like ComputeWorkTime but measures an open work session until now.
+func computeWorkTime(entries []Entry, now time.Time) (time.Duration, time.Time, time.Duration, error) {
 	if len(entries) == 0 {
 		return 0, time.Unix(0, 0), 0, ErrNoEntries
 	}
@@ -52,12 +193,13 @@ func ComputeWorkTime(entries []Entry) (time.Duration, time.Time, time.Duration,
 		//TODO check entry is for today
 
 		// current in working time slot? end it by virtual leave entry at the current time for live computation
-		entries = append(entries, Entry{Type: EntryTypeLeave, Time: time.Now()})
+		entries = append(entries, Entry{Type: EntryTypeLeave, Time: now})
 	}
 
 	stateNone := 0
 	stateWorking := 1
 	stateTrip := 2
+	statePause := 3
 	state := stateNone
 
 	var workTime time.Duration
@@ -77,6 +219,9 @@ func ComputeWorkTime(entries []Entry) (time.Duration, time.Time, time.Duration,
 				state = stateNone
 			} else if entries[i].Type == EntryTypeTrip {
 				state = stateTrip
+			} else if entries[i].Type == EntryTypePauseStart {
+				workTime += entries[i].Time.Sub(lastCome)
+				state = statePause
 			} else {
 				return 0, time.Unix(0, 0), 0, fmt.Errorf("2unexpected entry %q at index %d", entries[i].Type, i)
 			}
@@ -87,6 +232,16 @@ func ComputeWorkTime(entries []Entry) (time.Duration, time.Time, time.Duration,
 			} else {
 				return 0, time.Unix(0, 0), 0, fmt.Errorf("3unexpected entry %q at index %d", entries[i].Type, i)
 			}
+
+		} else if state == statePause {
+			if entries[i].Type == EntryTypePauseEnd {
+				lastCome = entries[i].Time
+				state = stateWorking
+			} else if entries[i].Type == EntryTypeLeave {
+				state = stateNone
+			} else {
+				return 0, time.Unix(0, 0), 0, fmt.Errorf("4unexpected entry %q at index %d", entries[i].Type, i)
+			}
 		}
 	}
 
@@ -95,6 +250,329 @@ func ComputeWorkTime(entries []Entry) (time.Duration, time.Time, time.Duration,
 	return workTime, entries[0].Time, breakTime, nil
 }
 
+// WorkedDuration returns the sum of all intervals between come and leave entries. An open interval at the end is measured until now.
+func WorkedDuration(entries []Entry) (time.Duration, error) {
+	return workedDuration(entries, time.Now())
+}
+
+func workedDuration(entries []Entry, now time.Time) (time.Duration, error) {
+	if len(entries) == 0 {
+		return 0, nil
+	}
+
+	if err := checkChronological(entries); err != nil {
+		return 0, err
+	}
+
+	workTime, _, _, err := computeWorkTime(entries, now)
+	if err != nil {
+		return 0, err
+	}
+	return workTime, nil
+}
+
+// Breaks returns the duration of every gap between two work sessions, i.e. from leaving or starting a pause until the next come or pause end. Business trips do not count as breaks.
+func Breaks(entries []Entry) ([]time.Duration, error) {
+	if err := checkChronological(entries); err != nil {
+		return nil, err
+	}
+	sessions, err := workSessions(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	breaks := make([]time.Duration, 0)
+	for i := 1; i < len(sessions); i++ {
+		breaks = append(breaks, sessions[i].Start.Sub(sessions[i-1].End))
+	}
+	return breaks, nil
+}
+
+// TotalBreak returns the sum of all breaks between work sessions. Unlike the accounted break time, this is the break actually taken.
+func TotalBreak(entries []Entry) (time.Duration, error) {
+	breaks, err := Breaks(entries)
+	if err != nil {
+		return 0, err
+	}
+	var total time.Duration
+	for _, b := range breaks {
+		total += b
+	}
+	return total, nil
+}
+
+// checkChronological returns an error if an entry is before its predecessor.
+func checkChronological(entries []Entry) error {
+	for i := 1; i < len(entries); i++ {
+		if entries[i].Time.Before(entries[i-1].Time) {
+			return fmt.Errorf("entry at index %d is before its predecessor", i)
+		}
+	}
+	return nil
+}
+
+// OvertimeForDay returns the difference between the accounted work time of a day and the target work time. Open work sessions are projected until now.
+func OvertimeForDay(entries []Entry, targetWorkTime time.Duration) (time.Duration, error) {
+	return overtimeForDay(entries, targetWorkTime, time.Now())
+}
+
+func overtimeForDay(entries []Entry, targetWorkTime time.Duration, now time.Time) (time.Duration, error) {
+	workTime, _, breakTime, err := computeWorkTime(entries, now)
+	if err != nil {
+		return 0, err
+	}
+	accountedWorkTime, _, err := ComputeAccountedWorkTime(workTime, breakTime)
+	if err != nil {
+		return 0, err
+	}
+	return accountedWorkTime - targetWorkTime, nil
+}
+
+// ProjectedLeaveTime returns the time of day at which the accounted work time will reach the target when continuing the current work session. Breaks taken so far are considered.
+func ProjectedLeaveTime(entries []Entry, targetWorkTime time.Duration) (time.Time, error) {
+	return projectedLeaveTime(entries, targetWorkTime, time.Now())
+}
+
+func projectedLeaveTime(entries []Entry, targetWorkTime time.Duration, now time.Time) (time.Time, error) {
+	if !IsClockedIn(entries) {
+		return time.Unix(0, 0), ErrNotClockedIn
+	}
+
+	_, startTime, breakTime, err := computeWorkTime(entries, now)
+	if err != nil {
+		return time.Unix(0, 0), err
+	}
+	return GetLeaveTime(startTime, breakTime, targetWorkTime)
+}
+
+// TimeUntilTarget returns the duration from now until the accounted work time reaches the target, e.g. to create a time.Timer for a notification. It is zero if the target has already been reached.
+// Breaks taken so far are considered, so it has to be computed again after every new entry.
+func TimeUntilTarget(entries []Entry, targetWorkTime time.Duration) (time.Duration, error) {
+	return WorkTimeOptions{}.TimeUntilTarget(entries, targetWorkTime)
+}
+
+// ScheduleTargetReached calls f in its own goroutine when the accounted work time reaches the target. Stop the returned timer to cancel the call, e.g. before scheduling it again for new entries.
+func ScheduleTargetReached(entries []Entry, targetWorkTime time.Duration, f func()) (*time.Timer, error) {
+	return WorkTimeOptions{}.ScheduleTargetReached(entries, targetWorkTime, f)
+}
+
+// untilTime returns the duration from now until t or zero if t has passed.
+func untilTime(t, now time.Time) time.Duration {
+	if !t.After(now) {
+		return 0
+	}
+	return t.Sub(now)
+}
+
+// Clock provides the current time to the work time helpers, e.g. to use a fixed time in tests.
+type Clock interface {
+	Now() time.Time
+}
+
+// realClock returns the actual time.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// WorkTimeOptions controls the work time helpers. The zero value behaves like the package level functions.
+type WorkTimeOptions struct {
+	// Rounding is the granularity that durations and times are rounded to, e.g. time.Minute to match the values shown by Matrix. Values are rounded to the nearest multiple. No rounding is applied if zero.
+	Rounding time.Duration
+	// Clock is used to measure open work sessions until now. The actual time is used if nil.
+	Clock Clock
+}
+
+// WorkedDuration is like the package level WorkedDuration but rounds the result.
+func (o WorkTimeOptions) WorkedDuration(entries []Entry) (time.Duration, error) {
+	workTime, err := workedDuration(entries, o.now())
+	if err != nil {
+		return 0, err
+	}
+	return o.roundDuration(workTime), nil
+}
+
+// OvertimeForDay is like the package level OvertimeForDay but rounds the result.
+func (o WorkTimeOptions) OvertimeForDay(entries []Entry, targetWorkTime time.Duration) (time.Duration, error) {
+	overtime, err := overtimeForDay(entries, targetWorkTime, o.now())
+	if err != nil {
+		return 0, err
+	}
+	return o.roundDuration(overtime), nil
+}
+
+// ProjectedLeaveTime is like the package level ProjectedLeaveTime but rounds the result.
+func (o WorkTimeOptions) ProjectedLeaveTime(entries []Entry, targetWorkTime time.Duration) (time.Time, error) {
+	leaveTime, err := projectedLeaveTime(entries, targetWorkTime, o.now())
+	if err != nil {
+		return leaveTime, err
+	}
+	return o.roundTime(leaveTime), nil
+}
+
+// TimeSinceLastEntry is like the package level TimeSinceLastEntry but rounds the elapsed time.
+func (o WorkTimeOptions) TimeSinceLastEntry(entries []Entry) (EntryType, time.Duration, bool) {
+	entryType, elapsed, ok := timeSinceLastEntry(entries, o.now())
+	return entryType, o.roundDuration(elapsed), ok
+}
+
+// TimeUntilTarget is like the package level TimeUntilTarget but uses the rounded projected leave time.
+func (o WorkTimeOptions) TimeUntilTarget(entries []Entry, targetWorkTime time.Duration) (time.Duration, error) {
+	now := o.now()
+	leaveTime, err := projectedLeaveTime(entries, targetWorkTime, now)
+	if err != nil {
+		return 0, err
+	}
+	return untilTime(o.roundTime(leaveTime), now), nil
+}
+
+// ScheduleTargetReached is like the package level ScheduleTargetReached but uses the rounded projected leave time.
+func (o WorkTimeOptions) ScheduleTargetReached(entries []Entry, targetWorkTime time.Duration, f func()) (*time.Timer, error) {
+	d, err := o.TimeUntilTarget(entries, targetWorkTime)
+	if err != nil {
+		return nil, err
+	}
+	return time.AfterFunc(d, f), nil
+}
+
+func (o WorkTimeOptions) now() time.Time {
+	if o.Clock == nil {
+		return realClock{}.Now()
+	}
+	return o.Clock.Now()
+}
+
+func (o WorkTimeOptions) roundDuration(d time.Duration) time.Duration {
+	if o.Rounding <= 0 {
+		return d
+	}
+	return d.Round(o.Rounding)
+}
+
+func (o WorkTimeOptions) roundTime(t time.Time) time.Time {
+	if o.Rounding <= 0 {
+		return t
+	}
+	return t.Round(o.Rounding)
+}
+
+// workSession describes a continuous interval of work. Business trips do not interrupt a session.
+type workSession struct {
+	Start time.Time
+	End   time.Time
+	// Open is true if the session has not ended yet. End is zero in this case.
+	Open bool
+}
+
+// workSessions returns all intervals of work from a chronologically ordered list of entries.
+func workSessions(entries []Entry) ([]workSession, error) {
+	stateNone := 0
+	stateWorking := 1
+	stateTrip := 2
+	statePause := 3
+	state := stateNone
+
+	sessions := make([]workSession, 0)
+	var start time.Time
+	for i, entry := range entries {
+		switch state {
+		case stateNone:
+			if entry.Type != EntryTypeCome {
+				return nil, fmt.Errorf("unexpected entry %q at index %d", entry.Type, i)
+			}
+			start = entry.Time
+			state = stateWorking
+
+		case stateWorking:
+			if entry.Type == EntryTypeLeave || entry.Type == EntryTypePauseStart {
+				sessions = append(sessions, workSession{Start: start, End: entry.Time})
+				state = stateNone
+				if entry.Type == EntryTypePauseStart {
+					state = statePause
+				}
+			} else if entry.Type == EntryTypeTrip {
+				state = stateTrip
+			} else {
+				return nil, fmt.Errorf("unexpected entry %q at index %d", entry.Type, i)
+			}
+
+		case stateTrip:
+			if entry.Type != EntryTypeCome {
+				return nil, fmt.Errorf("unexpected entry %q at index %d", entry.Type, i)
+			}
+			state = stateWorking
+
+		case statePause:
+			if entry.Type == EntryTypePauseEnd {
+				start = entry.Time
+				state = stateWorking
+			} else if entry.Type == EntryTypeLeave {
+				state = stateNone
+			} else {
+				return nil, fmt.Errorf("unexpected entry %q at index %d", entry.Type, i)
+			}
+		}
+	}
+
+	if state == stateWorking || state == stateTrip {
+		sessions = append(sessions, workSession{Start: start, Open: true})
+	}
+	return sessions, nil
+}
+
+// IsClockedIn returns true if the last entry opens a work session that has not been closed by a leave entry or a pause yet. A business trip counts as work.
+func IsClockedIn(entries []Entry) bool {
+	if len(entries) == 0 {
+		return false
+	}
+	lastType := entries[len(entries)-1].Type
+	return lastType == EntryTypeCome || lastType == EntryTypeTrip || lastType == EntryTypePauseEnd
+}
+
+// TimeSinceLastEntry returns the type of the latest entry and the time elapsed since then, e.g. the duration of the current break after a leave entry. The last return value is false if there are no entries.
+func TimeSinceLastEntry(entries []Entry) (EntryType, time.Duration, bool) {
+	return timeSinceLastEntry(entries, time.Now())
+}
+
+func timeSinceLastEntry(entries []Entry, now time.Time) (EntryType, time.Duration, bool) {
+	if len(entries) == 0 {
+		return "", 0, false
+	}
+	last := entries[0]
+	for _, entry := range entries[1:] {
+		if !entry.Time.Before(last.Time) {
+			last = entry
+		}
+	}
+
+	elapsed := now.Sub(last.Time)
+	if elapsed < 0 {
+		// entries in the future due to clock skew
+		elapsed = 0
+	}
+	return last.Type, elapsed, true
+}
+
+// CurrentSessionStart returns the time of the come or pause end entry that started the current work session. The second return value is false if not clocked in.
+func CurrentSessionStart(entries []Entry) (time.Time, bool) {
+	if !IsClockedIn(entries) {
+		return time.Time{}, false
+	}
+
+	// business trips do not interrupt a session, so walk back to the first come entry after the last leave or pause
+	start := -1
+	for i := len(entries) - 1; i >= 0 && entries[i].Type != EntryTypeLeave && entries[i].Type != EntryTypePauseStart; i-- {
+		if entries[i].Type == EntryTypeCome || entries[i].Type == EntryTypePauseEnd {
+			start = i
+		}
+	}
+	if start < 0 {
+		return time.Time{}, false
+	}
+	return entries[start].Time, true
+}
+
 // ComputeAccountedWorkTime returns the accounted work and break times according to country policies.
 func ComputeAccountedWorkTime(workTime, breakTime time.Duration) (time.Duration, time.Duration, error) {
 	// 09:10 - 15:37 -> 06:00 work, 00:27 break
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type accTimeCase struct {
@@ -90,6 +93,326 @@ func TestGetLeaveTime(t *testing.T) {
 	}
 }
 
+type workedCase struct {
+	Entries     []Entry
+	Worked      time.Duration
+	ExpectError bool
+}
+
+func TestWorkedDuration(t *testing.T) {
+	testCases := []workedCase{
+		{Entries: []Entry{}, Worked: dur(0, 0)},
+		{Entries: []Entry{come(8, 0), leave(16, 30)}, Worked: dur(8, 30)},
+		{Entries: []Entry{come(8, 0), leave(12, 0), come(12, 45), leave(17, 0)}, Worked: dur(8, 15)},
+		{Entries: []Entry{come(8, 0), come(9, 0), leave(17, 0)}, ExpectError: true},
+		{Entries: []Entry{come(8, 0), leave(12, 0), come(11, 0), leave(17, 0)}, ExpectError: true},
+		{Entries: []Entry{leave(8, 0), come(9, 0)}, ExpectError: true},
+	}
+
+	for i, c := range testCases {
+		t.Run(fmt.Sprintf("Test %d", i), func(t *testing.T) {
+			worked, err := WorkedDuration(c.Entries)
+			if c.ExpectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, c.Worked, worked)
+			}
+		})
+	}
+}
+
+func TestOvertimeForDay(t *testing.T) {
+	overtime, err := OvertimeForDay([]Entry{come(8, 0), leave(12, 0), come(12, 30), leave(17, 0)}, dur(8, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, dur(0, 30), overtime)
+
+	// missing break is deducted
+	overtime, err = OvertimeForDay([]Entry{come(8, 0), leave(15, 0)}, dur(8, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, -dur(1, 30), overtime)
+
+	_, err = OvertimeForDay([]Entry{}, dur(8, 0))
+	assert.Equal(t, ErrNoEntries, err)
+}
+
+func TestProjectedLeaveTimeNotClockedIn(t *testing.T) {
+	_, err := ProjectedLeaveTime([]Entry{}, dur(8, 0))
+	assert.Equal(t, ErrNotClockedIn, err)
+
+	_, err = ProjectedLeaveTime([]Entry{come(8, 0), leave(16, 30)}, dur(8, 0))
+	assert.Equal(t, ErrNotClockedIn, err)
+}
+
+func TestWorkTimeOptionsRounding(t *testing.T) {
+	entries := []Entry{come(8, 0), {Type: EntryTypeLeave, Time: tim(12, 0).Add(29 * time.Second)}}
+
+	worked, err := WorkTimeOptions{}.WorkedDuration(entries)
+	require.NoError(t, err)
+	assert.Equal(t, 4*time.Hour+29*time.Second, worked)
+
+	worked, err = WorkTimeOptions{Rounding: time.Minute}.WorkedDuration(entries)
+	require.NoError(t, err)
+	assert.Equal(t, 4*time.Hour, worked)
+
+	worked, err = WorkTimeOptions{Rounding: 15 * time.Minute}.WorkedDuration([]Entry{come(8, 0), leave(12, 8)})
+	require.NoError(t, err)
+	assert.Equal(t, 4*time.Hour+15*time.Minute, worked)
+
+	overtime, err := WorkTimeOptions{Rounding: time.Minute}.OvertimeForDay(entries, 3*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, overtime)
+
+	_, err = WorkTimeOptions{Rounding: time.Minute}.ProjectedLeaveTime(entries, 8*time.Hour)
+	assert.True(t, errors.Is(err, ErrNotClockedIn))
+}
+
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+func TestWorkTimeOptionsClock(t *testing.T) {
+	options := WorkTimeOptions{Clock: fixedClock(tim(12, 30))}
+	entries := []Entry{come(8, 0)}
+
+	worked, err := options.WorkedDuration(entries)
+	require.NoError(t, err)
+	assert.Equal(t, 4*time.Hour+30*time.Minute, worked)
+
+	overtime, err := options.OvertimeForDay(entries, 4*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Minute, overtime)
+
+	leaveTime, err := options.ProjectedLeaveTime(entries, 8*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, tim(16, 30), leaveTime)
+
+	entryType, elapsed, ok := options.TimeSinceLastEntry(entries)
+	require.True(t, ok)
+	assert.Equal(t, EntryTypeCome, entryType)
+	assert.Equal(t, 4*time.Hour+30*time.Minute, elapsed)
+}
+
+type timeUntilTargetCase struct {
+	Now      time.Time
+	Rounding time.Duration
+	Entries  []Entry
+	Until    time.Duration
+}
+
+func TestTimeUntilTarget(t *testing.T) {
+	testCases := []timeUntilTargetCase{
+		{Now: tim(12, 30), Entries: []Entry{come(8, 0)}, Until: dur(4, 0)},
+		// the break taken replaces the deducted minimum break
+		{Now: tim(14, 0), Entries: []Entry{come(8, 0), leave(12, 0), come(13, 0)}, Until: dur(3, 0)},
+		{Now: tim(17, 0), Entries: []Entry{come(8, 0)}, Until: 0},
+		{Now: tim(12, 0), Rounding: 15 * time.Minute, Entries: []Entry{come(8, 7)}, Until: dur(4, 30)},
+	}
+
+	for i, c := range testCases {
+		t.Run(fmt.Sprintf("Test %d", i), func(t *testing.T) {
+			until, err := WorkTimeOptions{Clock: fixedClock(c.Now), Rounding: c.Rounding}.TimeUntilTarget(c.Entries, dur(8, 0))
+			require.NoError(t, err)
+			assert.Equal(t, c.Until, until)
+		})
+	}
+
+	_, err := TimeUntilTarget([]Entry{come(8, 0), leave(16, 30)}, dur(8, 0))
+	assert.Equal(t, ErrNotClockedIn, err)
+}
+
+func TestScheduleTargetReached(t *testing.T) {
+	reached := make(chan struct{})
+	timer, err := WorkTimeOptions{Clock: fixedClock(tim(17, 0))}.ScheduleTargetReached([]Entry{come(8, 0)}, dur(8, 0), func() { close(reached) })
+	require.NoError(t, err)
+	defer timer.Stop()
+	select {
+	case <-reached:
+	case <-time.After(5 * time.Second):
+		t.Fatal("callback not called")
+	}
+
+	_, err = ScheduleTargetReached(nil, dur(8, 0), func() {})
+	assert.Equal(t, ErrNotClockedIn, err)
+}
+
+func TestBreaks(t *testing.T) {
+	entries := []Entry{come(8, 0), leave(12, 0), come(12, 30), pauseStart(14, 0), pauseEnd(14, 15), trip(15, 0), come(16, 0), leave(17, 0)}
+	breaks, err := Breaks(entries)
+	require.NoError(t, err)
+	assert.Equal(t, []time.Duration{30 * time.Minute, 15 * time.Minute}, breaks)
+	total, err := TotalBreak(entries)
+	require.NoError(t, err)
+	assert.Equal(t, 45*time.Minute, total)
+
+	// an open session ends the last break
+	breaks, err = Breaks([]Entry{come(8, 0), leave(12, 0), come(12, 45)})
+	require.NoError(t, err)
+	assert.Equal(t, []time.Duration{45 * time.Minute}, breaks)
+
+	breaks, err = Breaks(nil)
+	require.NoError(t, err)
+	assert.Equal(t, []time.Duration{}, breaks)
+
+	_, err = Breaks([]Entry{come(8, 0), leave(7, 0)})
+	assert.Error(t, err)
+	_, err = TotalBreak([]Entry{leave(8, 0)})
+	assert.Error(t, err)
+}
+
+func TestWorkSessions(t *testing.T) {
+	sessions, err := workSessions([]Entry{come(8, 0), trip(9, 0), come(10, 0), pauseStart(12, 0), pauseEnd(12, 30), leave(15, 0), come(16, 0)})
+	require.NoError(t, err)
+	assert.Equal(t, []workSession{
+		{Start: tim(8, 0), End: tim(12, 0)},
+		{Start: tim(12, 30), End: tim(15, 0)},
+		{Start: tim(16, 0), Open: true},
+	}, sessions)
+
+	_, err = workSessions([]Entry{come(8, 0), come(9, 0)})
+	assert.Error(t, err)
+}
+
+func TestDiffEntries(t *testing.T) {
+	oldEntries := []Entry{leave(12, 0), come(8, 0)}
+	newEntries := []Entry{come(12, 30), come(8, 0), trip(12, 0), leave(12, 0), come(12, 30)}
+	assert.Equal(t, []Entry{trip(12, 0), come(12, 30)}, DiffEntries(oldEntries, newEntries))
+
+	assert.Empty(t, DiffEntries(newEntries, oldEntries))
+	assert.Equal(t, []Entry{come(8, 0)}, DiffEntries(nil, []Entry{come(8, 0)}))
+}
+
+func TestMergeEntries(t *testing.T) {
+	history := []Entry{come(8, 0), leave(12, 0), come(12, 30)}
+	fetched := []Entry{come(12, 30), leave(17, 0), come(8, 0)}
+	assert.Equal(t, []Entry{come(8, 0), leave(12, 0), come(12, 30), leave(17, 0)}, MergeEntries(history, fetched))
+	assert.Equal(t, MergeEntries(history, fetched), MergeEntries(fetched, history))
+
+	// the first occurrence is kept, so the time zone of a is preserved
+	cet := time.FixedZone("CET", 3600)
+	merged := MergeEntries([]Entry{{Type: EntryTypeCome, Time: tim(8, 0).In(cet)}}, []Entry{come(8, 0)})
+	require.Len(t, merged, 1)
+	assert.Equal(t, cet, merged[0].Time.Location())
+
+	// entries with equal time keep their order
+	assert.Equal(t, []Entry{leave(12, 0), come(12, 0), trip(12, 0)}, MergeEntries([]Entry{leave(12, 0), come(12, 0)}, []Entry{trip(12, 0), come(12, 0)}))
+
+	assert.Equal(t, []Entry{}, MergeEntries(nil, nil))
+}
+
+func TestDedupEntries(t *testing.T) {
+	entries := []Entry{come(8, 0), come(8, 0), leave(12, 0), come(12, 30), leave(12, 0), leave(17, 0), leave(17, 0), leave(17, 0)}
+	assert.Equal(t, []Entry{come(8, 0), leave(12, 0), come(12, 30), leave(12, 0), leave(17, 0)}, DedupEntries(entries))
+	assert.Equal(t, []Entry{}, DedupEntries(nil))
+
+	_, err := WorkedDuration([]Entry{come(8, 0), come(8, 0), leave(12, 0)})
+	assert.Error(t, err)
+	worked, err := WorkedDuration(DedupEntries([]Entry{come(8, 0), come(8, 0), leave(12, 0)}))
+	require.NoError(t, err)
+	assert.Equal(t, 4*time.Hour, worked)
+}
+
+func TestLastN(t *testing.T) {
+	nextDay := Entry{Type: EntryTypeCome, Time: tim(8, 0).AddDate(0, 0, 1)}
+	entries := []Entry{nextDay, come(8, 0), leave(12, 0), trip(12, 0), come(12, 30)}
+	assert.Equal(t, []Entry{nextDay, come(12, 30), leave(12, 0)}, LastN(entries, 3))
+	assert.Equal(t, []Entry{nextDay, come(12, 30), leave(12, 0), trip(12, 0), come(8, 0)}, LastN(entries, 10))
+	assert.Equal(t, nextDay, entries[0], "input must not be modified")
+
+	assert.Equal(t, []Entry{}, LastN(entries, 0))
+	assert.Equal(t, []Entry{}, LastN(entries, -1))
+	assert.Equal(t, []Entry{}, LastN(nil, 3))
+}
+
+func TestFilterByType(t *testing.T) {
+	entries := []Entry{come(8, 0), leave(12, 0), come(12, 30), leave(17, 0)}
+	assert.Equal(t, []Entry{come(8, 0), come(12, 30)}, FilterByType(entries, EntryTypeCome))
+	assert.Equal(t, []Entry{}, FilterByType(entries, EntryTypeTrip))
+	assert.Equal(t, []Entry{}, FilterByType(nil, EntryTypeCome))
+}
+
+func TestFilterByDay(t *testing.T) {
+	nextDay := Entry{Type: EntryTypeLeave, Time: tim(0, 30).AddDate(0, 0, 1)}
+	entries := []Entry{come(8, 0), leave(23, 59), nextDay}
+	assert.Equal(t, []Entry{come(8, 0), leave(23, 59)}, FilterByDay(entries, tim(15, 0)))
+	assert.Equal(t, []Entry{nextDay}, FilterByDay(entries, nextDay.Time))
+
+	// the day is interpreted in the time zone of the given time
+	assert.Equal(t, []Entry{leave(23, 59), nextDay}, FilterByDay(entries, tim(15, 0).In(time.FixedZone("UTC+2", 2*3600)).AddDate(0, 0, 1)))
+	assert.Equal(t, []Entry{}, FilterByDay(nil, tim(15, 0)))
+}
+
+func TestTimeSinceLastEntry(t *testing.T) {
+	entryType, elapsed, ok := timeSinceLastEntry([]Entry{come(8, 0), leave(12, 0)}, tim(12, 23))
+	require.True(t, ok)
+	assert.Equal(t, EntryTypeLeave, entryType)
+	assert.Equal(t, 23*time.Minute, elapsed)
+
+	// the latest entry is used independent of the order
+	entryType, elapsed, ok = timeSinceLastEntry([]Entry{come(12, 30), come(8, 0), leave(12, 0)}, tim(13, 0))
+	require.True(t, ok)
+	assert.Equal(t, EntryTypeCome, entryType)
+	assert.Equal(t, 30*time.Minute, elapsed)
+
+	_, elapsed, ok = timeSinceLastEntry([]Entry{come(8, 0)}, tim(7, 59))
+	require.True(t, ok)
+	assert.Equal(t, time.Duration(0), elapsed)
+
+	_, _, ok = TimeSinceLastEntry(nil)
+	assert.False(t, ok)
+}
+
+func TestCurrentSessionStart(t *testing.T) {
+	start, ok := CurrentSessionStart([]Entry{})
+	assert.False(t, ok)
+
+	start, ok = CurrentSessionStart([]Entry{come(8, 0), leave(12, 0)})
+	assert.False(t, ok)
+
+	start, ok = CurrentSessionStart([]Entry{come(8, 0), leave(12, 0), come(12, 30)})
+	assert.True(t, ok)
+	assert.Equal(t, tim(12, 30), start)
+
+	start, ok = CurrentSessionStart([]Entry{come(8, 0), trip(10, 0), come(11, 0), trip(13, 0)})
+	assert.True(t, ok)
+	assert.Equal(t, tim(8, 0), start)
+}
+
+func TestEntryJSON(t *testing.T) {
+	entry := Entry{Type: EntryTypeCome, Time: time.Date(2019, time.November, 1, 8, 15, 0, 0, time.FixedZone("CET", 3600))}
+
+	data, err := json.Marshal(entry)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"time":"2019-11-01T08:15:00+01:00","type":"come"}`, string(data))
+
+	var parsed Entry
+	assert.NoError(t, json.Unmarshal(data, &parsed))
+	assert.True(t, entry.Time.Equal(parsed.Time))
+	assert.Equal(t, entry.Type, parsed.Type)
+}
+
+func come(hours, minutes int) Entry {
+	return Entry{Type: EntryTypeCome, Time: tim(hours, minutes)}
+}
+
+func leave(hours, minutes int) Entry {
+	return Entry{Type: EntryTypeLeave, Time: tim(hours, minutes)}
+}
+
+func trip(hours, minutes int) Entry {
+	return Entry{Type: EntryTypeTrip, Time: tim(hours, minutes)}
+}
+
+func pauseStart(hours, minutes int) Entry {
+	return Entry{Type: EntryTypePauseStart, Time: tim(hours, minutes)}
+}
+
+func pauseEnd(hours, minutes int) Entry {
+	return Entry{Type: EntryTypePauseEnd, Time: tim(hours, minutes)}
+}
+
 func dur(hours, minutes int) time.Duration {
 	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute
 }